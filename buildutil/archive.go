@@ -0,0 +1,161 @@
+package buildutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarballRoot is the directory within release tarballs
+// which holds the binaries.
+const tarballRoot = "docker"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isTarball returns whether the file is a gzipped tarball
+// rather than a single binary.
+func isTarball(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	b, err := bufio.NewReader(f).Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(b, gzipMagic), nil
+}
+
+type bundleBinary struct {
+	name string
+	path string
+}
+
+// bundleBinaries returns the binaries in the source directory
+// along with the name they should be installed as. When the
+// directory is a build bundle, only files with a hash file are
+// considered binaries, each must match its hash and the version
+// suffix is removed from the name. Otherwise all regular files
+// are returned.
+func bundleBinaries(source string) ([]bundleBinary, error) {
+	fis, err := ioutil.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashed bool
+	for _, fi := range fis {
+		if filepath.Ext(fi.Name()) == ".sha256" {
+			hashed = true
+			break
+		}
+	}
+
+	suffix := versionSuffix(source)
+	var binaries []bundleBinary
+	for _, fi := range fis {
+		name := fi.Name()
+		if hashed {
+			if filepath.Ext(name) != ".sha256" {
+				continue
+			}
+			name = name[:len(name)-7]
+		} else if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(source, name)
+
+		// Stat to follow symlinks to binaries
+		fi, err := os.Stat(path)
+		if err != nil {
+			if hashed && os.IsNotExist(err) {
+				return nil, fmt.Errorf("missing file %s", path)
+			}
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if hashed {
+			if err := checkHashFile(path, path+".sha256"); err != nil {
+				return nil, err
+			}
+		}
+
+		if suffix != "" && strings.HasSuffix(name, suffix) {
+			name = name[:len(name)-len(suffix)]
+		}
+		binaries = append(binaries, bundleBinary{
+			name: name,
+			path: path,
+		})
+	}
+
+	return binaries, nil
+}
+
+// writeBundleTarball writes a gzipped tarball of the binaries
+// in the source directory using the same layout as the
+// release tarballs.
+func writeBundleTarball(w io.Writer, source string) error {
+	binaries, err := bundleBinaries(source)
+	if err != nil {
+		return err
+	}
+	if len(binaries) == 0 {
+		return fmt.Errorf("no binaries found in %s", source)
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     tarballRoot + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}); err != nil {
+		return err
+	}
+	for _, b := range binaries {
+		if err := addTarFile(tw, tarballRoot+"/"+b.name, b.path); err != nil {
+			return fmt.Errorf("error adding %s: %v", b.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0755,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,533 @@
+package buildutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BuildOptions configures building Docker from source
+type BuildOptions struct {
+	// Engine is the moby checkout the daemon is built from
+	Engine *SourceTree
+
+	// CLI is the docker/cli checkout the client is built from,
+	// the client is built from the engine source when not set
+	// and the engine still contains the client.
+	CLI *SourceTree
+
+	// Dir is the directory to build in, the built binaries
+	// are collected in the "binaries" directory within it.
+	Dir string
+
+	// Dynamic builds dynamically linked binaries
+	Dynamic bool
+
+	// Bundles are the make.sh bundles to build the engine with,
+	// defaults to the bundles building the binaries.
+	Bundles []string
+
+	// BuildTags are the Go build tags for the engine, defaults
+	// to excluding the devicemapper graph driver.
+	BuildTags []string
+
+	// LDFlags are additional Go linker flags
+	LDFlags string
+
+	// Experimental enables experimental features in the engine
+	Experimental bool
+
+	// Env is the environment the build scripts are run with
+	Env []string
+
+	// BuildEnv is additional environment for the build scripts
+	// which affects the build output, such as CGO_ENABLED.
+	BuildEnv []string
+
+	// Container builds the engine inside a container rather
+	// than on the host
+	Container *ContainerOptions
+
+	// Output receives the output of the build scripts as
+	// they run, defaults to stderr.
+	Output io.Writer
+
+	// LogFile is the file the output of the build scripts is
+	// also written to, defaults to "build.log" within Dir.
+	LogFile string
+
+	// Context stops the running build script when done, the
+	// script is interrupted and given time to exit.
+	Context context.Context
+
+	// log is the writer for the output of the build scripts,
+	// writing to both Output and LogFile.
+	log io.Writer
+
+	// image is the ID of the image container builds run in
+	image string
+}
+
+// BuildResult is the output of building from source
+type BuildResult struct {
+	// Binaries is the directory holding the built binaries
+	Binaries string
+
+	// Info describes the build, including the effective
+	// build settings
+	Info BuildInfo
+
+	// LogFile is the file holding the output of the build
+	LogFile string
+}
+
+// BuildInfoFile is the file within the build directory the
+// build information is recorded in
+const BuildInfoFile = "build-info.json"
+
+// unrecordedEnv is build environment which does not affect
+// the build output and may hold credentials
+var unrecordedEnv = map[string]bool{
+	"HTTP_PROXY":  true,
+	"HTTPS_PROXY": true,
+	"NO_PROXY":    true,
+	"http_proxy":  true,
+	"https_proxy": true,
+	"no_proxy":    true,
+}
+
+// BuildSource builds the daemon and client from source, returning
+// the directory holding the built binaries as a single installable
+// set. The directory may be put in a build cache as one version.
+// The build information is also recorded in the build directory.
+func BuildSource(opts BuildOptions) (*BuildResult, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BuildResult{
+		Binaries: filepath.Join(opts.Dir, "binaries"),
+		Info: BuildInfo{
+			BuildFlags: opts.BuildFlags(),
+		},
+		LogFile: opts.LogFile,
+	}
+	// Remove binaries left from a previous build in the
+	// same directory
+	if err := os.RemoveAll(result.Binaries); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(result.Binaries, 0755); err != nil {
+		return nil, err
+	}
+	logFile, err := os.Create(opts.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	opts.log = io.MultiWriter(opts.Output, logFile)
+
+	if opts.Container != nil {
+		if opts.image, err = buildImage(opts); err != nil {
+			return nil, err
+		}
+		result.Info.GoVersion, err = containerGoVersion(opts, opts.image)
+	} else {
+		result.Info.GoVersion, err = goVersion(opts.Env)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range []*SourceTree{opts.Engine, opts.CLI} {
+		if s != nil && !result.Info.Dirty {
+			if result.Info.Dirty, err = gitDirty(s.Dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.Engine != nil {
+		commit, err := buildEngine(opts, result.Binaries)
+		if err != nil {
+			return nil, err
+		}
+		result.Info.GitCommit = commit
+	}
+	if opts.CLI != nil {
+		commit, err := buildCLI(opts, result.Binaries)
+		if err != nil {
+			return nil, err
+		}
+		if result.Info.GitCommit == "" {
+			result.Info.GitCommit = commit
+		}
+	}
+
+	b, err := json.MarshalIndent(result.Info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(opts.Dir, BuildInfoFile), b, 0644); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// withDefaults checks the options and returns them with
+// the default bundles and build tags set.
+func (opts BuildOptions) withDefaults() (BuildOptions, error) {
+	if opts.Engine == nil && opts.CLI == nil {
+		return opts, fmt.Errorf("no source to build")
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	if opts.LogFile == "" {
+		opts.LogFile = filepath.Join(opts.Dir, BuildLogFile)
+	}
+	if opts.Container != nil && opts.CLI != nil {
+		return opts, fmt.Errorf("container builds only support building the engine")
+	}
+	if opts.Engine != nil && len(opts.Bundles) == 0 {
+		opts.Bundles = EngineBundles(opts.Engine, opts.Dynamic, opts.CLI == nil)
+	}
+	if opts.BuildTags == nil {
+		opts.BuildTags = []string{"exclude_graphdriver_devicemapper"}
+	}
+	return opts, nil
+}
+
+// buildStopTimeout is how long a build script is given to exit
+// once interrupted before it is killed
+const buildStopTimeout = 10 * time.Second
+
+// command returns the command to run a build script, interrupted
+// when the build context is done.
+func (opts BuildOptions) command(name string, args ...string) *exec.Cmd {
+	if opts.Context == nil {
+		return exec.Command(name, args...)
+	}
+	cmd := exec.CommandContext(opts.Context, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = buildStopTimeout
+	return cmd
+}
+
+// goVersion returns the version of Go on the host run with
+// the environment, such as "go version go1.10 linux/amd64".
+func goVersion(env []string) (string, error) {
+	cmd := exec.Command("go", "version")
+	if len(env) > 0 {
+		cmd.Env = env
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting Go version: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// BuildFlags returns the effective build settings, the environment
+// passed to the build of each source. The bundles are only set once
+// defaulted by BuildSource.
+func (opts BuildOptions) BuildFlags() []string {
+	var flags []string
+	if len(opts.Bundles) > 0 {
+		flags = append(flags, "bundles="+strings.Join(opts.Bundles, ","))
+	}
+	if opts.Dynamic {
+		flags = append(flags, "dynamic")
+	}
+	if opts.Container != nil {
+		if opts.Container.Image != "" {
+			flags = append(flags, "container="+opts.Container.Image)
+		} else {
+			flags = append(flags, "container")
+		}
+	}
+	// Only record the settings passed to the build of each source
+	if opts.Engine != nil {
+		flags = append(flags, opts.engineEnv()...)
+	}
+	if opts.CLI != nil && opts.LDFlags != "" {
+		flags = append(flags, "LDFLAGS="+opts.LDFlags)
+	}
+	for _, e := range opts.BuildEnv {
+		if !unrecordedEnv[strings.SplitN(e, "=", 2)[0]] {
+			flags = append(flags, e)
+		}
+	}
+	return flags
+}
+
+// engineEnv returns the environment configuring the make.sh build
+func (opts BuildOptions) engineEnv() []string {
+	env := []string{"DOCKER_BUILDTAGS=" + strings.Join(opts.BuildTags, " ")}
+	if opts.LDFlags != "" {
+		env = append(env, "DOCKER_LDFLAGS="+opts.LDFlags)
+	}
+	if opts.Experimental {
+		env = append(env, "DOCKER_EXPERIMENTAL=1")
+	}
+	return env
+}
+
+// binaryBundles are the make.sh bundles whose output is installed
+var binaryBundles = map[string]bool{
+	"binary":           true,
+	"binary-client":    true,
+	"binary-daemon":    true,
+	"dynbinary":        true,
+	"dynbinary-client": true,
+	"dynbinary-daemon": true,
+}
+
+// BuildsBinaries returns whether the build produces binaries to
+// install. Builds of only other bundles, such as test-unit or
+// cross, leave their output in the build directory.
+func (opts BuildOptions) BuildsBinaries() bool {
+	if opts.CLI != nil || (opts.Engine != nil && len(opts.Bundles) == 0) {
+		return true
+	}
+	for _, bundle := range opts.Bundles {
+		if binaryBundles[bundle] {
+			return true
+		}
+	}
+	return false
+}
+
+// EngineBundles returns the make.sh bundles which build the daemon,
+// along with the client when it is still part of the engine source
+// and no separate client source is built.
+func EngineBundles(engine *SourceTree, dynamic, withClient bool) []string {
+	if dynamic {
+		return []string{"dynbinary"}
+	}
+	if withClient && fileExists(filepath.Join(engine.Dir, "hack", "make", "binary-client")) {
+		return []string{"binary-client", "binary-daemon"}
+	}
+	return []string{"binary-daemon"}
+}
+
+var createdBinaryRegexp = regexp.MustCompile("Created binary:[[:space:]]+([[:graph:]]+)")
+
+// buildEngine builds the engine with hack/make.sh and copies the
+// binaries from each binary bundle directory into the binaries
+// directory, returning the commit built. The output of other
+// bundles is left in the build directory.
+func buildEngine(opts BuildOptions, binaries string) (string, error) {
+	engine := opts.Engine
+	buildscript := filepath.Join(engine.Dir, "hack", "make.sh")
+	if !fileExists(buildscript) {
+		return "", fmt.Errorf("build script not found, ensure Docker is checked out correctly and up to date: missing %s", buildscript)
+	}
+
+	commit, err := gitCommit(engine.Dir)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("Building engine %s at %s", engine.Dir, commit)
+
+	stamp, err := gitStamp(engine.Dir, commit)
+	if err != nil {
+		return "", err
+	}
+	buildEnv := append(append(append([]string{}, opts.BuildEnv...), opts.engineEnv()...), "DOCKER_GITCOMMIT="+stamp)
+	out := newBuildOutput(opts.log)
+	var buildDir string
+	if opts.Container != nil {
+		// Bundles are written to the bind mounted source
+		buildDir = engine.Dir
+		if err := runContainerBuild(opts, opts.image, buildEnv, out); err != nil {
+			return "", opts.buildError("engine", out, err)
+		}
+	} else {
+		var env []string
+		buildDir, env, err = engineBuildDir(opts)
+		if err != nil {
+			return "", err
+		}
+		logrus.Infof("Building %s in %s", strings.Join(opts.Bundles, ", "), buildDir)
+
+		cmd := opts.command(buildscript, opts.Bundles...)
+		cmd.Dir = buildDir
+		cmd.Env = append(env, buildEnv...)
+		// The same writer for both keeps the output in order
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return "", opts.buildError("engine", out, err)
+		}
+	}
+	out.Close()
+
+	sourceDirs := map[string]struct{}{}
+	for _, file := range out.created {
+		if opts.Container != nil && strings.HasPrefix(file, containerSourceDir+"/") {
+			file = filepath.Join(buildDir, filepath.FromSlash(strings.TrimPrefix(file, containerSourceDir+"/")))
+		} else if !filepath.IsAbs(file) {
+			file = filepath.Join(buildDir, file)
+		}
+		if !binaryBundles[filepath.Base(filepath.Dir(file))] {
+			logrus.Infof("Created %s", file)
+			continue
+		}
+		sourceDirs[filepath.Dir(file)] = struct{}{}
+	}
+	if len(sourceDirs) == 0 {
+		for _, bundle := range opts.Bundles {
+			if binaryBundles[bundle] {
+				return "", fmt.Errorf("engine build failed: could not find binaries")
+			}
+		}
+	}
+	for sourceDir := range sourceDirs {
+		logrus.Debugf("Copying bundle directory %s to %s", sourceDir, binaries)
+		if err := CopyBundleBinaries(sourceDir, binaries); err != nil {
+			return "", err
+		}
+	}
+
+	return commit, nil
+}
+
+// engineBuildDir prepares the directory to run make.sh in for a
+// host build and returns it along with the build environment.
+func engineBuildDir(opts BuildOptions) (string, []string, error) {
+	engine := opts.Engine
+	env := append(append([]string{}, opts.Env...), engine.GoEnv()...)
+	if engine.Modules {
+		// Module builds must run in the source tree
+		return engine.Dir, env, nil
+	}
+
+	// Build from a GOPATH holding the generated files, with
+	// the source linked into a GOPATH when outside of one
+	buildGoPath := filepath.Join(opts.Dir, "engine")
+	buildDir := filepath.Join(buildGoPath, "src", filepath.FromSlash(ImportPathEngine))
+
+	if packageCache := os.Getenv("DBUILDER_PACKAGE_CACHE"); packageCache != "" {
+		if err := os.MkdirAll(buildGoPath, 0755); err != nil {
+			return "", nil, err
+		}
+		os.Symlink(packageCache, filepath.Join(buildGoPath, "pkg"))
+	}
+
+	sourceGoPath, err := engine.LinkGOPATH(filepath.Join(opts.Dir, "engine-source"))
+	if err != nil {
+		return "", nil, fmt.Errorf("error linking source into GOPATH: %v", err)
+	}
+	gopaths := []string{buildGoPath, filepath.Join(engine.Dir, "vendor"), sourceGoPath}
+	if gopath := os.Getenv("GOPATH"); gopath != "" && gopath != sourceGoPath {
+		gopaths = append(gopaths, gopath)
+	}
+	env = append(env, "GOPATH="+strings.Join(gopaths, string(os.PathListSeparator)))
+
+	if err := CopyFile(filepath.Join(engine.Dir, "VERSION"), filepath.Join(buildDir, "VERSION"), 0644); err != nil {
+		return "", nil, err
+	}
+	for _, f := range []string{
+		filepath.Join("dockerinit", "dockerinit.go"),
+		filepath.Join("dockerversion", "version_lib.go"),
+		filepath.Join("dockerversion", "useragent.go"),
+	} {
+		if fileExists(filepath.Join(engine.Dir, f)) {
+			if err := CopyFile(filepath.Join(engine.Dir, f), filepath.Join(buildDir, f), 0644); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	return buildDir, env, nil
+}
+
+// buildCLI builds the client with the docker/cli build scripts
+// and copies it into the binaries directory.
+func buildCLI(opts BuildOptions, binaries string) (string, error) {
+	cli := opts.CLI
+	script := "binary"
+	if opts.Dynamic {
+		script = "dynbinary"
+	}
+	buildscript := filepath.Join(cli.Dir, "scripts", "build", script)
+	if !fileExists(buildscript) {
+		return "", fmt.Errorf("build script not found, ensure the CLI is checked out correctly and up to date: missing %s", buildscript)
+	}
+
+	commit, err := gitCommit(cli.Dir)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("Building CLI %s at %s", cli.Dir, commit)
+
+	env := append(append([]string{}, opts.Env...), cli.GoEnv()...)
+	buildDir := cli.Dir
+	if !cli.Modules {
+		// The build scripts must be run from the source
+		// within a GOPATH
+		gopath, err := cli.LinkGOPATH(filepath.Join(opts.Dir, "cli-source"))
+		if err != nil {
+			return "", fmt.Errorf("error linking source into GOPATH: %v", err)
+		}
+		buildDir = filepath.Join(gopath, "src", filepath.FromSlash(ImportPathCLI))
+		env = append(env, "GOPATH="+gopath)
+	}
+
+	cmd := opts.command(filepath.Join(".", "scripts", "build", script))
+	cmd.Dir = buildDir
+	stamp, err := gitStamp(cli.Dir, commit)
+	if err != nil {
+		return "", err
+	}
+	cmd.Env = append(append(env, opts.BuildEnv...), "GITCOMMIT="+stamp)
+	if opts.LDFlags != "" {
+		cmd.Env = append(cmd.Env, "LDFLAGS="+opts.LDFlags)
+	}
+	cmd.Stdout = opts.log
+	cmd.Stderr = opts.log
+	if err := cmd.Run(); err != nil {
+		return "", opts.buildError("CLI", nil, err)
+	}
+
+	// The build scripts link build/docker to the binary
+	// named with the platform and version
+	if err := CopyFile(filepath.Join(cli.Dir, "build", "docker"), filepath.Join(binaries, "docker"), 0755); err != nil {
+		return "", fmt.Errorf("CLI build failed: %v", err)
+	}
+
+	return commit, nil
+}
+
+// gitStamp returns the commit stamped into the binaries built
+// from dir, suffixed with "-dirty" when there are uncommitted
+// changes.
+func gitStamp(dir, commit string) (string, error) {
+	dirty, err := gitDirty(dir)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		commit += "-dirty"
+	}
+	return commit, nil
+}
+
+// gitCommit returns the commit checked out in dir
+func gitCommit(dir string) (string, error) {
+	return git(dir, "rev-parse", "HEAD")
+}
@@ -0,0 +1,115 @@
+package buildutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+)
+
+// BuildLogFile is the file within the build directory the
+// output of the build scripts is written to by default
+const BuildLogFile = "build.log"
+
+// BuildError is returned when a build script fails
+type BuildError struct {
+	// Source is the source being built, "engine" or "CLI"
+	Source string
+
+	// Bundle is the make.sh bundle being built when the
+	// build failed, empty when not known.
+	Bundle string
+
+	// ExitCode is the exit code of the build script, zero
+	// when the script did not exit.
+	ExitCode int
+
+	// LogFile is the file holding the output of the build
+	LogFile string
+
+	// Err is the error running the build script
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	msg := e.Source + " build failed"
+	if e.Bundle != "" {
+		msg += " in bundle " + e.Bundle
+	}
+	if e.ExitCode != 0 {
+		msg += fmt.Sprintf(" with exit code %d", e.ExitCode)
+	} else {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	if e.LogFile != "" {
+		msg += ", see " + e.LogFile
+	}
+	return msg
+}
+
+var makingBundleRegexp = regexp.MustCompile("---> Making bundle: ([[:graph:]]+)")
+
+// buildOutput passes the output of a build script through
+// to a writer, keeping track of the bundle being built and
+// the binaries created from each line of output.
+type buildOutput struct {
+	w       io.Writer
+	line    []byte
+	bundle  string
+	created []string
+}
+
+func newBuildOutput(w io.Writer) *buildOutput {
+	return &buildOutput{w: w}
+}
+
+func (o *buildOutput) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.line = append(o.line, p[:n]...)
+	for {
+		i := bytes.IndexByte(o.line, '\n')
+		if i < 0 {
+			break
+		}
+		o.scan(o.line[:i])
+		o.line = o.line[i+1:]
+	}
+	return n, err
+}
+
+// Close scans the last line of output when it
+// did not end with a newline.
+func (o *buildOutput) Close() error {
+	if len(o.line) > 0 {
+		o.scan(o.line)
+		o.line = nil
+	}
+	return nil
+}
+
+func (o *buildOutput) scan(line []byte) {
+	if match := makingBundleRegexp.FindSubmatch(line); match != nil {
+		o.bundle = string(match[1])
+	} else if match := createdBinaryRegexp.FindSubmatch(line); match != nil {
+		o.created = append(o.created, string(match[1]))
+	}
+}
+
+// buildError returns the error for the failed build script,
+// including the bundle being built and the exit code.
+func (opts BuildOptions) buildError(source string, out *buildOutput, err error) error {
+	e := &BuildError{
+		Source:  source,
+		LogFile: opts.LogFile,
+		Err:     err,
+	}
+	if out != nil {
+		out.Close()
+		e.Bundle = out.bundle
+	}
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() > 0 {
+		e.ExitCode = ee.ExitCode()
+	}
+	return e
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -21,6 +22,10 @@ var (
 	// ErrCannotDownloadCommit is used when downloading is required but
 	// a build has been specified by commit hash.
 	ErrCannotDownloadCommit = errors.New("cannot download build by commit")
+
+	// ErrNotCached is used when a version is required to be in
+	// the cache but could not be found.
+	ErrNotCached = errors.New("version not cached")
 )
 
 // BuildCache is a cache for storing specific versions of Docker
@@ -29,7 +34,9 @@ type BuildCache interface {
 	IsCached(versionutil.Version) bool
 
 	// PutVersion puts the given file path in the cache using the
-	// provided version for the cache.
+	// provided version for the cache. The path may be a single
+	// binary, a release tarball, or a directory of binaries which
+	// will be stored together as a tarball.
 	PutVersion(versionutil.Version, string) error
 
 	// InstallVersion installs the provided version to the given
@@ -38,16 +45,93 @@ type BuildCache interface {
 	InstallVersion(versionutil.Version, string) error
 }
 
+// artifactFetcher is implemented by caches which can copy the
+// cached artifact for a version without installing it
+type artifactFetcher interface {
+	// fetchArtifact copies the cached artifact for the version
+	// into the given directory and returns the path to the
+	// artifact. The version is not downloaded, ErrNotCached is
+	// returned if the version is not in the cache.
+	fetchArtifact(versionutil.Version, string) (string, error)
+}
+
+// fetchArtifact copies the cached artifact for the version from
+// the cache into dir. ErrNotCached is returned when the cache
+// cannot fetch artifacts.
+func fetchArtifact(c BuildCache, v versionutil.Version, dir string) (string, error) {
+	if f, ok := c.(artifactFetcher); ok {
+		return f.fetchArtifact(v, dir)
+	}
+	return "", ErrNotCached
+}
+
 type fsBuildCache struct {
 	root string
+
+	// maxSize is the maximum total size in bytes of the
+	// cached files, zero for no limit
+	maxSize int64
+
+	// maxEntries is the maximum number of cached versions,
+	// zero for no limit
+	maxEntries int
+
+	// pinned are the keys of versions which are never evicted
+	pinned map[string]struct{}
 }
 
 // NewFSBuildCache returns a build cache using the provided
 // root directory as the cache storage.
-func NewFSBuildCache(root string) BuildCache {
-	return &fsBuildCache{
-		root: root,
+func NewFSBuildCache(root string, opts ...FSOption) BuildCache {
+	return newFSBuildCache(root, opts...)
+}
+
+func newFSBuildCache(root string, opts ...FSOption) *fsBuildCache {
+	bc := &fsBuildCache{
+		root:   root,
+		pinned: map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	return bc
+}
+
+// NewBuildCache returns a build cache for the given location.
+// The location may be a local directory, an OCI registry
+// repository as "oci://registry/repository", or an S3-compatible
+// bucket as "s3://bucket/prefix", or a cache server URL. S3
+// credentials and endpoint are taken from the AWS environment
+// variables and the cache server upload token is taken from
+// DEVTOOLS_CACHE_TOKEN. The options are only used for local
+// directories.
+func NewBuildCache(location string, opts ...FSOption) (BuildCache, error) {
+	switch {
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return NewHTTPBuildCache(location, os.Getenv("DEVTOOLS_CACHE_TOKEN"))
+	case strings.HasPrefix(location, "oci://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "https"
+		if host := u.Hostname(); host == "localhost" || host == "127.0.0.1" {
+			scheme = "http"
+		}
+		registry := &url.URL{
+			Scheme: scheme,
+			User:   u.User,
+			Host:   u.Host,
+		}
+		return NewOCIBuildCache(registry.String(), strings.Trim(u.Path, "/"))
+	case strings.HasPrefix(location, "s3://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3BuildCache(u.Host, u.Path, S3ConfigFromEnv())
 	}
+	return NewFSBuildCache(location, opts...), nil
 }
 
 func (bc *fsBuildCache) versionFile(v versionutil.Version) string {
@@ -55,18 +139,37 @@ func (bc *fsBuildCache) versionFile(v versionutil.Version) string {
 		panic("cannot get release file with commit")
 	}
 
-	versionFile := filepath.Join(bc.root, v.VersionString())
-	if v.Tag != "" {
-		versionFile = versionFile + "-" + v.Tag
-	}
+	return filepath.Join(bc.root, versionKey(v))
+}
+
+func (bc *fsBuildCache) entryFile(v versionutil.Version) string {
+	return filepath.Join(bc.root, versionKey(v))
+}
 
-	return versionFile
+// versionKey returns the name used to store a version in
+// a cache, either the commit or the version with its tag.
+// Versions for another architecture include the architecture.
+func versionKey(v versionutil.Version) string {
+	key := v.Commit
+	if key == "" {
+		key = v.VersionString()
+		if v.Tag != "" {
+			key = key + "-" + v.Tag
+		}
+		if v.Component != "" {
+			key = v.Component + "-" + key
+		}
+	}
+	if v.Arch != "" {
+		key = key + "." + v.Arch
+	}
+	return key
 }
 
 func (bc *fsBuildCache) getCached(v versionutil.Version) string {
 	logrus.Debugf("Looking for cached version of %s", v)
 	if v.Commit != "" {
-		commitFile := filepath.Join(bc.root, v.Commit)
+		commitFile := bc.entryFile(v)
 		if _, err := os.Stat(commitFile); err == nil {
 			return commitFile
 		}
@@ -82,17 +185,6 @@ func (bc *fsBuildCache) getCached(v versionutil.Version) string {
 	return ""
 }
 
-func initFile(f string) string {
-	dir, name := filepath.Split(f)
-	if strings.HasPrefix(name, "docker") {
-		name = "dockerinit" + name[6:]
-	} else {
-		name = name + "-init"
-	}
-	return dir + name
-
-}
-
 func (bc *fsBuildCache) tempFile() (*os.File, error) {
 	return ioutil.TempFile(bc.root, "tmp-")
 }
@@ -104,20 +196,63 @@ func (bc *fsBuildCache) cleanupTempFile(tmp *os.File) error {
 	return os.Remove(tmp.Name())
 }
 
-func (bc *fsBuildCache) saveVersion(tmp *os.File, v versionutil.Version) (string, error) {
+func (bc *fsBuildCache) saveVersion(tmp *os.File, v versionutil.Version, p Provenance) (string, error) {
 	source := tmp.Name()
 	if err := tmp.Close(); err != nil {
 		log.Printf("Failed to close temp file %v: %s", tmp.Name(), err)
 	}
 	// TODO: Ensure source version matches
 
-	target := bc.versionFile(v)
+	target := bc.entryFile(v)
 	if err := os.Rename(source, target); err != nil {
 		return "", err
 	}
+	if err := bc.writeMetadata(v, target, p); err != nil {
+		return "", err
+	}
 	return target, nil
 }
 
+// copyFile copies the source binary to a temp file in the cache and
+// renames it to the target, replacing any cached binary without
+// readers ever seeing a partially written file.
+func (bc *fsBuildCache) copyFile(source, target string) error {
+	tf, err := bc.tempFile()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		bc.cleanupTempFile(tf)
+		return err
+	}
+	_, err = io.Copy(tf, f)
+	f.Close()
+	if err == nil {
+		err = tf.Chmod(0755)
+	}
+	if err != nil {
+		bc.cleanupTempFile(tf)
+		return fmt.Errorf("error copying %s: %v", source, err)
+	}
+	if err := tf.Close(); err != nil {
+		os.Remove(tf.Name())
+		return err
+	}
+	if err := os.Rename(tf.Name(), target); err != nil {
+		os.Remove(tf.Name())
+		return err
+	}
+	return nil
+}
+
+func (bc *fsBuildCache) location() string {
+	if root, err := filepath.Abs(bc.root); err == nil {
+		return root
+	}
+	return bc.root
+}
+
 func (bc *fsBuildCache) IsCached(v versionutil.Version) bool {
 	return bc.getCached(v) != ""
 }
@@ -132,6 +267,36 @@ func binaryDigest(source string) (digest.Digest, error) {
 }
 
 func (bc *fsBuildCache) PutVersion(v versionutil.Version, source string) error {
+	return bc.putVersion(v, source, Provenance{
+		Source: SourcePut,
+		Path:   absPath(source),
+	})
+}
+
+func (bc *fsBuildCache) putBuild(v versionutil.Version, source string, info BuildInfo) error {
+	return bc.putVersion(v, source, Provenance{
+		Source: SourceBuild,
+		Path:   absPath(source),
+		Build:  &info,
+	})
+}
+
+func (bc *fsBuildCache) putVersion(v versionutil.Version, source string, p Provenance) error {
+	if err := bc.put(v, source, p); err != nil {
+		return err
+	}
+	return bc.evict(versionKey(v))
+}
+
+func (bc *fsBuildCache) put(v versionutil.Version, source string, p Provenance) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return bc.putBundle(v, source, p)
+	}
+
 	cached := bc.getCached(v)
 	if cached != "" {
 		sourceDgst, err := binaryDigest(source)
@@ -143,105 +308,168 @@ func (bc *fsBuildCache) PutVersion(v versionutil.Version, source string) error {
 			return err
 		}
 		if sourceDgst == cachedDgst {
+			bc.touch(cached)
 			return nil
 		}
 		logrus.Debugf("Overwriting %s with %s", cached, source)
-	} else if v.Commit != "" {
-		cached = filepath.Join(bc.root, v.Commit)
 	} else {
-		cached = bc.versionFile(v)
+		cached = bc.entryFile(v)
 	}
-	if err := CopyFile(source, cached, 0755); err != nil {
+	if err := bc.copyFile(source, cached); err != nil {
 		return err
 	}
 	sourceInit := initFile(source)
 	if _, err := os.Stat(sourceInit); err == nil {
 		cachedInit := initFile(cached)
-		if err := CopyFile(sourceInit, cachedInit, 0755); err != nil {
+		if err := bc.copyFile(sourceInit, cachedInit); err != nil {
 			return err
 		}
+	} else if err := os.Remove(initFile(cached)); err != nil && !os.IsNotExist(err) {
+		// Remove any init binary left from a previous put
+		return err
 	}
 
-	return nil
+	return bc.writeMetadata(v, cached, p)
 }
 
-// installLegacyDocker install pre 1.11 binaries
-func installLegacyDocker(cached, cachedInit, target string) error {
-	if err := CopyFile(cached, target, 0755); err != nil {
+// putBundle stores all binaries in the source directory as a
+// single tarball so the whole set is installed together.
+func (bc *fsBuildCache) putBundle(v versionutil.Version, source string, p Provenance) error {
+	tf, err := bc.tempFile()
+	if err != nil {
+		return err
+	}
+	if err := writeBundleTarball(tf, source); err != nil {
+		if err := bc.cleanupTempFile(tf); err != nil {
+			// Just log
+			log.Printf("Error cleaning up temp file %v: %s", tf.Name(), err)
+		}
 		return err
 	}
 
-	targetInit := initFile(target)
-	if _, err := os.Stat(cachedInit); err == nil {
-		// Create target file, check if name starts with docker, replace with dockerinit
-		return CopyFile(cachedInit, targetInit, 0755)
+	// Remove any init binary left from a single binary entry
+	if err := os.Remove(initFile(bc.entryFile(v))); err != nil && !os.IsNotExist(err) {
+		return err
 	}
 
-	if _, err := os.Stat(targetInit); err == nil {
-		// Truncate file, do not remove since operator may only have access
-		// to file and not directory. Future calls may rely on overwriting
-		// the content of this file.
-		vf, err := os.OpenFile(targetInit, os.O_TRUNC|os.O_WRONLY, 0755)
-		if err != nil {
-			return err
-		}
-		return vf.Close()
+	cached, err := bc.saveVersion(tf, v, p)
+	if err != nil {
+		return err
 	}
+	logrus.Debugf("Stored bundle %s as %s", source, cached)
 
 	return nil
 }
 
-func (bc *fsBuildCache) InstallVersion(v versionutil.Version, target string) error {
+func (bc *fsBuildCache) fetchArtifact(v versionutil.Version, dir string) (string, error) {
 	cached := bc.getCached(v)
 	if cached == "" {
-		logrus.Debugf("No cached file, downloading")
-		if v.Commit != "" {
-			return ErrCannotDownloadCommit
-		}
-		downloadURL := v.DownloadURL()
-		if downloadURL == "" {
-			return fmt.Errorf("failed to get download location for %#v", v)
-		}
-		logrus.Debugf("Downloading from %s", downloadURL)
-		resp, err := http.Get(downloadURL)
-		if err != nil {
-			return err
+		return "", ErrNotCached
+	}
+	bc.touch(cached)
+	artifact := filepath.Join(dir, versionKey(v))
+	if err := CopyFile(cached, artifact, 0755); err != nil {
+		return "", err
+	}
+	if cachedInit := initFile(cached); fileExists(cachedInit) {
+		if err := CopyFile(cachedInit, initFile(artifact), 0755); err != nil {
+			return "", err
 		}
+	}
+	return artifact, nil
+}
 
-		tf, err := bc.tempFile()
-		if err != nil {
-			return err
-		}
+func fileExists(f string) bool {
+	_, err := os.Stat(f)
+	return err == nil
+}
 
-		logrus.Debugf("Copying to %s", tf)
-		_, err = io.Copy(tf, resp.Body)
-		if err != nil {
-			if err := bc.cleanupTempFile(tf); err != nil {
-				// Just log
-				log.Printf("Error cleaning up temp file %v: %s", tf.Name(), err)
-			}
-			return err
-		}
+func (bc *fsBuildCache) InstallVersion(v versionutil.Version, target string) error {
+	cached, err := bc.ensureCached(v)
+	if err != nil {
+		return err
+	}
 
-		logrus.Debugf("Saving file %s", tf)
-		cached, err = bc.saveVersion(tf, v)
-		if err != nil {
-			return err
-		}
-	} else {
+	return installArtifact(v, cached, target)
+}
+
+// ensureCached returns the cached file for the version,
+// downloading the version into the cache if not found.
+func (bc *fsBuildCache) ensureCached(v versionutil.Version) (string, error) {
+	cached := bc.getCached(v)
+	if cached != "" {
 		logrus.Debugf("Found cached file %s", cached)
+		bc.touch(cached)
+		return cached, nil
 	}
 
-	// If Less than 1.11
-	nonLegacyVersion := versionutil.StaticVersion(1, 11, 0)
-	nonLegacyVersion.Tag = "rc1"
-	if v.LessThan(nonLegacyVersion) {
-		cachedInit := initFile(cached)
+	logrus.Debugf("No cached file, downloading")
+	tf, err := bc.tempFile()
+	if err != nil {
+		return "", err
+	}
 
-		if err := installLegacyDocker(cached, cachedInit, filepath.Join(target, "docker")); err != nil {
-			return err
+	logrus.Debugf("Copying to %s", tf.Name())
+	if err := download(v, tf); err != nil {
+		if err := bc.cleanupTempFile(tf); err != nil {
+			// Just log
+			log.Printf("Error cleaning up temp file %v: %s", tf.Name(), err)
 		}
-		return nil
+		return "", err
+	}
+
+	logrus.Debugf("Saving file %s", tf.Name())
+	cached, err = bc.saveVersion(tf, v, Provenance{
+		Source: SourceDownload,
+		URL:    v.DownloadURL(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return cached, bc.evict(versionKey(v))
+}
+
+// download writes the release artifact for the version
+// retrieved from the Docker download location.
+func download(v versionutil.Version, w io.Writer) error {
+	if v.Commit != "" {
+		return ErrCannotDownloadCommit
+	}
+	downloadURL := v.DownloadURL()
+	if downloadURL == "" {
+		return fmt.Errorf("failed to get download location for %#v", v)
+	}
+	logrus.Debugf("Downloading from %s", downloadURL)
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status downloading %s: %s", downloadURL, resp.Status)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// installArtifact installs the binaries from a cached artifact
+// for the version to the target directory.
+func installArtifact(v versionutil.Version, cached, target string) error {
+	if v.Component != "" {
+		return installComponent(v, cached, target)
+	}
+	release := v.Release()
+
+	// Releases before 1.11 and single binary builds are not
+	// packaged as tarballs
+	tarball, err := isTarball(cached)
+	if err != nil {
+		return err
+	}
+	if !tarball {
+		return installLegacyDocker(release, cached, initFile(cached), filepath.Join(target, release.Binaries[0]))
 	}
 
 	logrus.Debugf("Installing multi-binary version %s", v)
@@ -256,22 +484,54 @@ func (bc *fsBuildCache) InstallVersion(v versionutil.Version, target string) err
 		return fmt.Errorf("error untarring: %v", err)
 	}
 
-	binRoot := filepath.Join(td, "docker")
+	binDirs := []string{tarballRoot}
+	if release.BinaryDir != tarballRoot {
+		binDirs = append(binDirs, release.BinaryDir)
+	}
+	var binRoot string
+	for _, dir := range binDirs {
+		if fi, err := os.Stat(filepath.Join(td, dir)); err == nil && fi.IsDir() {
+			binRoot = filepath.Join(td, dir)
+			break
+		}
+	}
+	if binRoot == "" {
+		return fmt.Errorf("no binary directory found in tarball, expected one of %v", binDirs)
+	}
+
 	fis, err := ioutil.ReadDir(binRoot)
 	if err != nil {
 		return err
 	}
+	var installedInit bool
+	installed := map[string]bool{}
 	for _, fi := range fis {
 		name := fi.Name()
 		if fi.IsDir() {
 			logrus.Debugf("Skipping installation of directory: %s", name)
 			continue
 		}
+		if name == "dockerinit" {
+			if release.InitBinary == "" {
+				logrus.Debugf("Skipping unused init binary")
+				continue
+			}
+			installedInit = true
+		}
 		finalPath := filepath.Join(target, name)
 		logrus.Debugf("Installing %s to %s", name, finalPath)
 		if err := CopyFile(filepath.Join(binRoot, name), finalPath, 0755); err != nil {
 			return err
 		}
+		installed[name] = true
+	}
+	for _, name := range release.Binaries {
+		if !installed[name] {
+			logrus.Warnf("Release binary %s missing from %s", name, v)
+		}
+	}
+	if isLegacy(release) && !installedInit {
+		return removeStaleInit(filepath.Join(target, "dockerinit"))
 	}
 
 	return nil
@@ -0,0 +1,268 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Missing %s: %v", name, err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("Unexpected content for %s: %q, expected %q", name, b, content)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "buildutil-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return td
+}
+
+func commitVersion(t *testing.T, s string) versionutil.Version {
+	v, err := versionutil.ParseVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestPutBundleDirectory(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	bundle := filepath.Join(td, "bundles", "17.06.0-dev", "binary-daemon")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd-17.06.0-dev":        "dockerd",
+		"dockerd-17.06.0-dev.sha256": "1bd0438fadfd70c4ff2660fc4d66365a082805dbda9efcd272ee63bd8c14dac7  dockerd-17.06.0-dev",
+		"docker-containerd":          "containerd",
+		"docker-containerd.sha256":   "2397d068a8d1552a4c3f9147ba9c94e086bfd66fb2acb5bbaf47197105127d5f  docker-containerd",
+		"docker-runc":                "runc",
+		"docker-runc.sha256":         "501e03083c73bb097e77509a3cb92cedc7f44d6d53e1e54eb4c40f23f03307e9  docker-runc",
+		"docker-runc.md5":            "0000 docker-runc",
+	})
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if c.IsCached(v) {
+		t.Fatal("Version should not be cached")
+	}
+	if err := c.PutVersion(v, bundle); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsCached(v) {
+		t.Fatal("Version should be cached")
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"dockerd":           "dockerd",
+		"docker-containerd": "containerd",
+		"docker-runc":       "runc",
+	})
+	if _, err := os.Stat(filepath.Join(target, "docker-runc.md5")); err == nil {
+		t.Fatal("Hash file should not be installed")
+	}
+}
+
+func TestPutBundleHashMismatch(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	bundle := filepath.Join(td, "bundles", "17.06.0-dev", "binary-daemon")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd-17.06.0-dev":        "dockerd",
+		"dockerd-17.06.0-dev.sha256": "0000000000000000000000000000000000000000000000000000000000000000  dockerd-17.06.0-dev",
+	})
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if err := c.PutVersion(v, bundle); err == nil {
+		t.Fatal("Expected error putting binary not matching its hash")
+	}
+	if c.IsCached(v) {
+		t.Fatal("Version should not be cached")
+	}
+}
+
+func TestPutLegacyBinary(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	writeFiles(t, filepath.Join(td, "build"), map[string]string{
+		"docker-1.9.0-dev":     "docker",
+		"dockerinit-1.9.0-dev": "dockerinit",
+	})
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "1.9.0-dev@abcdef1234")
+	if err := c.PutVersion(v, filepath.Join(td, "build", "docker-1.9.0-dev")); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker":     "docker",
+		"dockerinit": "dockerinit",
+	})
+
+	testReplaceLegacyBinary(t, c)
+}
+
+// testReplaceLegacyBinary puts a binary with an init binary in the
+// cache, then replaces it with a binary without one, and checks the
+// stale init binary is not installed with the new binary.
+func testReplaceLegacyBinary(t *testing.T, c BuildCache) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	writeFiles(t, filepath.Join(td, "build1"), map[string]string{
+		"docker-1.9.0-dev":     "docker1",
+		"dockerinit-1.9.0-dev": "dockerinit1",
+	})
+	writeFiles(t, filepath.Join(td, "build2"), map[string]string{
+		"docker-1.9.0-dev": "docker2",
+	})
+
+	v := commitVersion(t, "1.9.0-dev@abcdef5678")
+	for _, build := range []string{"build1", "build2"} {
+		if err := c.PutVersion(v, filepath.Join(td, build, "docker-1.9.0-dev")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker": "docker2",
+	})
+	if _, err := os.Stat(filepath.Join(target, "dockerinit")); err == nil {
+		t.Fatal("Stale init binary should not be installed")
+	}
+}
+
+// testBundleRoundTrip puts a bundle directory in the cache and
+// checks that installing the version installs every binary.
+func testBundleRoundTrip(t *testing.T, c BuildCache) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	bundle := filepath.Join(td, "bundle")
+	writeFiles(t, bundle, map[string]string{
+		"docker":      "docker",
+		"dockerd":     "dockerd",
+		"docker-runc": "runc",
+	})
+
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if c.IsCached(v) {
+		t.Fatal("Version should not be cached")
+	}
+	if err := c.PutVersion(v, bundle); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsCached(v) {
+		t.Fatal("Version should be cached")
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker":      "docker",
+		"dockerd":     "dockerd",
+		"docker-runc": "runc",
+	})
+}
+
+func TestReplaceBinaryKeepsReaders(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	writeFiles(t, td, map[string]string{
+		"build1/docker": "docker1",
+		"build2/docker": "docker2",
+	})
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if err := c.PutVersion(v, filepath.Join(td, "build1", "docker")); err != nil {
+		t.Fatal(err)
+	}
+	// A reader of the cached binary must not see the replacement
+	// written over the file it has open
+	f, err := os.Open(c.(*fsBuildCache).entryFile(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := c.PutVersion(v, filepath.Join(td, "build2", "docker")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "docker1" {
+		t.Fatalf("Open binary changed to %q", b)
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker": "docker2",
+	})
+}
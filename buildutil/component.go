@@ -0,0 +1,72 @@
+package buildutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// installComponent installs the binaries of a component, such
+// as containerd or runc, from a cached artifact. Binaries which
+// were installed under their bundled names by an earlier Docker
+// install are also replaced so the daemon uses the component.
+func installComponent(v versionutil.Version, cached, target string) error {
+	release := v.Release()
+	if len(release.Binaries) == 0 {
+		return fmt.Errorf("unknown component %q", v.Component)
+	}
+
+	tarball, err := isTarball(cached)
+	if err != nil {
+		return err
+	}
+
+	binaries := map[string]string{}
+	if tarball {
+		td, err := ioutil.TempDir("", "")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(td)
+
+		if err := exec.Command("tar", "-xzf", cached, "-C", td).Run(); err != nil {
+			return fmt.Errorf("error untarring: %v", err)
+		}
+		for _, name := range release.Binaries {
+			for _, dir := range []string{release.BinaryDir, tarballRoot} {
+				if p := filepath.Join(td, dir, name); fileExists(p) {
+					binaries[name] = p
+					break
+				}
+			}
+			if binaries[name] == "" {
+				return fmt.Errorf("missing %s binary in %s", name, v)
+			}
+		}
+	} else {
+		if len(release.Binaries) > 1 {
+			return fmt.Errorf("expected tarball for %s", v)
+		}
+		binaries[release.Binaries[0]] = cached
+	}
+
+	for name, p := range binaries {
+		targets := []string{filepath.Join(target, name)}
+		if bundled, ok := release.BundledNames[name]; ok && fileExists(filepath.Join(target, bundled)) {
+			targets = append(targets, filepath.Join(target, bundled))
+		}
+		for _, t := range targets {
+			logrus.Debugf("Installing %s to %s", name, t)
+			if err := CopyFile(p, t, 0755); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+func TestInstallComponents(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	source := filepath.Join(td, "source")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTarball(t, filepath.Join(source, "containerd.tar.gz"), map[string]string{
+		"bin/containerd":        "containerd 1.2.5",
+		"bin/containerd-shim":   "containerd-shim 1.2.5",
+		"bin/containerd-stress": "containerd-stress 1.2.5",
+		"bin/ctr":               "ctr 1.2.5",
+	})
+	writeFiles(t, source, map[string]string{
+		"runc.amd64": "runc 1.0.0-rc6",
+	})
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	containerd, err := versionutil.ParseComponentVersion(versionutil.ComponentContainerd, "1.2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runc, err := versionutil.ParseComponentVersion(versionutil.ComponentRunc, "1.0.0-rc6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutVersion(containerd, filepath.Join(source, "containerd.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutVersion(runc, filepath.Join(source, "runc.amd64")); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, cacheDir, map[string]string{
+		"runc-1.0.0-rc6": "runc 1.0.0-rc6",
+	})
+	if !c.IsCached(containerd) {
+		t.Fatal("Expected containerd to be cached")
+	}
+	docker := commitVersion(t, "1.2.5")
+	if c.IsCached(docker) {
+		t.Fatal("Docker version should not match component version")
+	}
+
+	// Binaries bundled with an earlier Docker install
+	target := filepath.Join(td, "target")
+	writeFiles(t, target, map[string]string{
+		"docker":                "docker",
+		"docker-containerd":     "bundled containerd",
+		"docker-runc":           "bundled runc",
+		"docker-containerd-ctr": "bundled ctr",
+	})
+	for _, v := range []versionutil.Version{containerd, runc} {
+		if err := c.InstallVersion(v, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFiles(t, target, map[string]string{
+		"docker":                "docker",
+		"containerd":            "containerd 1.2.5",
+		"containerd-shim":       "containerd-shim 1.2.5",
+		"ctr":                   "ctr 1.2.5",
+		"docker-containerd":     "containerd 1.2.5",
+		"docker-containerd-ctr": "ctr 1.2.5",
+		"runc":                  "runc 1.0.0-rc6",
+		"docker-runc":           "runc 1.0.0-rc6",
+	})
+	if fileExists(filepath.Join(target, "containerd-stress")) {
+		t.Fatal("Only release binaries should be installed")
+	}
+	if fileExists(filepath.Join(target, "docker-containerd-shim")) {
+		t.Fatal("Bundled names should only replace existing binaries")
+	}
+
+	results, err := VerifyFSBuildCache(cacheDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Name == "containerd-1.2.5" && r.Err != nil {
+			t.Fatalf("Unexpected verification error: %v", r.Err)
+		}
+	}
+}
+
+func TestInstallPlugin(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	writeFiles(t, td, map[string]string{
+		"buildx-v0.5.1.linux-amd64": "buildx 0.5.1",
+	})
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "buildx-0.5.1")
+	if err := c.PutVersion(v, filepath.Join(td, "buildx-v0.5.1.linux-amd64")); err != nil {
+		t.Fatal(err)
+	}
+	pluginDir := filepath.Join(td, "cli-plugins")
+	if err := c.InstallVersion(v, pluginDir); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, pluginDir, map[string]string{
+		"docker-buildx": "buildx 0.5.1",
+	})
+}
+
+func TestInstallContainerdWithoutShim(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	// containerd 2.0 no longer ships the v1 shim
+	writeTestTarball(t, filepath.Join(td, "containerd.tar.gz"), map[string]string{
+		"bin/containerd":              "containerd 2.0.0",
+		"bin/containerd-shim-runc-v2": "containerd-shim-runc-v2 2.0.0",
+		"bin/ctr":                     "ctr 2.0.0",
+	})
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "containerd-2.0.0")
+	if err := c.PutVersion(v, filepath.Join(td, "containerd.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"containerd": "containerd 2.0.0",
+		"ctr":        "ctr 2.0.0",
+	})
+}
@@ -0,0 +1,111 @@
+package buildutil
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// containerSourceDir is the directory the source is mounted at
+// in the build container, the working directory of the upstream
+// development image.
+const containerSourceDir = "/go/src/" + ImportPathEngine
+
+// ContainerOptions configures building the engine in a container
+type ContainerOptions struct {
+	// Docker is the docker client used to run the build,
+	// defaults to "docker" from the PATH.
+	Docker string
+
+	// Image is the image to build in, defaults to building
+	// the Dockerfile from the engine source.
+	Image string
+}
+
+func (c *ContainerOptions) docker() string {
+	if c.Docker == "" {
+		return "docker"
+	}
+	return c.Docker
+}
+
+// buildImage returns the ID of the image to build in, building the
+// development image from the source Dockerfile when no image is
+// given and pulling the given image when it is not present.
+func buildImage(opts BuildOptions) (string, error) {
+	docker := opts.Container.docker()
+	out := opts.log
+	if out == nil {
+		out = opts.Output
+	}
+
+	image := opts.Container.Image
+	if image == "" {
+		commit, err := gitCommit(opts.Engine.Dir)
+		if err != nil {
+			return "", err
+		}
+		image = "dockerdevtools-build:" + commit
+		logrus.Infof("Building image %s from %s", image, opts.Engine.Dir)
+		cmd := opts.command(docker, "build", "-t", image, opts.Engine.Dir)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("error building image: %v", err)
+		}
+	} else if id, err := imageID(docker, image); err == nil {
+		return id, nil
+	} else {
+		logrus.Infof("Pulling image %s", image)
+		cmd := exec.Command(docker, "pull", image)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("error pulling image: %v", err)
+		}
+	}
+	return imageID(docker, image)
+}
+
+// imageID returns the ID of the local image
+func imageID(docker, image string) (string, error) {
+	out, err := exec.Command(docker, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return "", fmt.Errorf("error inspecting image %s: %v", image, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// containerGoVersion returns the version of Go in the image
+func containerGoVersion(opts BuildOptions, image string) (string, error) {
+	out, err := exec.Command(opts.Container.docker(), "run", "--rm", "--entrypoint", "go", image, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting Go version in %s: %v", image, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runContainerBuild runs make.sh in the build container with the
+// engine source bind mounted. The output of make.sh is written
+// to out.
+func runContainerBuild(opts BuildOptions, image string, env []string, out io.Writer) error {
+	args := []string{
+		"run", "--rm", "--privileged",
+		"-v", opts.Engine.Dir + ":" + containerSourceDir,
+		"-w", containerSourceDir,
+	}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, image, "hack/make.sh")
+	args = append(args, opts.Bundles...)
+
+	logrus.Infof("Building %s in %s", strings.Join(opts.Bundles, ", "), image)
+	cmd := opts.command(opts.Container.docker(), args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
@@ -0,0 +1,177 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDockerScript is a fake docker client which records its
+// arguments and runs containers on the host in the bind mount.
+// Images have the ID from the image.id file next to the script.
+const testDockerScript = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$@" >> "$dir/docker.log"
+case "$1" in
+build)
+	exit 0
+	;;
+image)
+	cat "$dir/image.id"
+	exit 0
+	;;
+run)
+	shift
+	src=.
+	entrypoint=
+	while [ $# -gt 0 ]; do
+		case "$1" in
+		--rm|--privileged) shift ;;
+		--entrypoint) entrypoint=$2; shift 2 ;;
+		-v) src=${2%%:*}; shift 2 ;;
+		-w) shift 2 ;;
+		-e) export "$2"; shift 2 ;;
+		*) break ;;
+		esac
+	done
+	shift
+	cd "$src" && exec $entrypoint "$@"
+	;;
+esac
+exit 1
+`
+
+// dockerCalls returns the logged calls of the fake docker client
+// starting with the command.
+func dockerCalls(t *testing.T, docker, command string) []string {
+	log := filepath.Join(filepath.Dir(docker), "docker.log")
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, call := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if strings.HasPrefix(call, command+" ") {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func TestBuildContainer(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	commit := gitInit(t, filepath.Join(td, "moby"), map[string]string{
+		"hack/make.sh":            testMakeScript,
+		"hack/make/binary-daemon": "",
+		"Dockerfile":              "FROM golang\n",
+		"VERSION":                 "1.0.0-dev",
+	})
+	engine, err := DetectSource(filepath.Join(td, "moby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker := filepath.Join(td, "bin", "docker")
+	writeFiles(t, filepath.Dir(docker), map[string]string{
+		"docker":   testDockerScript,
+		"image.id": "sha256:1\n",
+	})
+	if err := os.Chmod(docker, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := BuildSource(BuildOptions{
+		Engine:       engine,
+		Dir:          filepath.Join(td, "build"),
+		Experimental: true,
+		Container: &ContainerOptions{
+			Docker: docker,
+		},
+		Output: ioutil.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, result.Binaries, map[string]string{
+		"dockerd": "dockerd " + commit + "\n",
+	})
+	if !strings.HasPrefix(result.Info.GoVersion, "go version ") {
+		t.Fatalf("Unexpected Go version %q", result.Info.GoVersion)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(td, "bin", "docker.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(calls) != 4 {
+		t.Fatalf("Unexpected docker calls: %q", calls)
+	}
+	image := "dockerdevtools-build:" + commit
+	if expected := "build -t " + image + " " + engine.Dir; calls[0] != expected {
+		t.Fatalf("Unexpected build call %q, expected %q", calls[0], expected)
+	}
+	if expected := "image inspect --format {{.Id}} " + image; calls[1] != expected {
+		t.Fatalf("Unexpected inspect call %q, expected %q", calls[1], expected)
+	}
+	if expected := "run --rm --entrypoint go sha256:1 version"; calls[2] != expected {
+		t.Fatalf("Unexpected version call %q, expected %q", calls[2], expected)
+	}
+	for _, expected := range []string{
+		"-v " + engine.Dir + ":" + containerSourceDir,
+		"-e DOCKER_EXPERIMENTAL=1",
+		"-e DOCKER_GITCOMMIT=" + commit,
+		"sha256:1 hack/make.sh binary-daemon",
+	} {
+		if !strings.Contains(calls[3], expected) {
+			t.Errorf("Run call %q missing %q", calls[3], expected)
+		}
+	}
+
+	// Rebuilding the image changes the build key
+	os.Remove(filepath.Join(td, "bin", "docker.log"))
+	opts := BuildOptions{
+		Engine:    engine,
+		Container: &ContainerOptions{Docker: docker},
+	}
+	key1, err := opts.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, filepath.Dir(docker), map[string]string{
+		"image.id": "sha256:2\n",
+	})
+	key2, err := opts.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key1 == key2 {
+		t.Fatalf("Expected key to change with the image, got %s", key1)
+	}
+
+	os.Remove(filepath.Join(td, "bin", "docker.log"))
+	if _, err := BuildSource(BuildOptions{
+		Engine: engine,
+		Dir:    filepath.Join(td, "build2"),
+		Container: &ContainerOptions{
+			Docker: docker,
+			Image:  "docker-dev:custom",
+		},
+		Output: ioutil.Discard,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := dockerCalls(t, docker, "build"); len(calls) != 0 {
+		t.Fatalf("Expected no build with given image: %q", calls)
+	}
+	if calls := dockerCalls(t, docker, "image"); len(calls) != 1 || calls[0] != "image inspect --format {{.Id}} docker-dev:custom" {
+		t.Fatalf("Expected given image to be inspected: %q", calls)
+	}
+}
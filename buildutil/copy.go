@@ -73,6 +73,21 @@ func CopyBundleBinaries(source, target string) error {
 	return nil
 }
 
+// CopyBinaries copies the binaries from a bundle directory or
+// a directory of binaries to the target directory.
+func CopyBinaries(source, target string) error {
+	binaries, err := bundleBinaries(source)
+	if err != nil {
+		return err
+	}
+	for _, b := range binaries {
+		if err := copyBinary(filepath.Base(b.path), b.name, source, target); err != nil {
+			return fmt.Errorf("copy failed: %v", err)
+		}
+	}
+	return nil
+}
+
 // versionSuffix gets the version suffix for a source
 // directory, the expected format is "*/bundles/<version>/binarydir/"
 // If the source directory is different the version will be empty.
@@ -112,18 +127,30 @@ func copyBinary(sourceName, targetName, sourceDir, targetDir string) error {
 	if err := CopyFile(source, dest, 0755); err != nil {
 		return err
 	}
-	if b, err := ioutil.ReadFile(source + ".sha256"); err == nil {
-		if i := bytes.IndexRune(b, ' '); i > 0 {
-			b = b[:i]
-		}
-		expectedHash := make([]byte, hex.DecodedLen(len(b)))
-		if _, err := hex.Decode(expectedHash, b); err != nil {
-			return err
-		}
-		if err := hashCheck(dest, expectedHash, sha256.New()); err != nil {
-			return err
-		}
+	if err := checkHashFile(dest, source+".sha256"); err != nil && !os.IsNotExist(err) {
+		return err
 	}
 
 	return nil
 }
+
+// checkHashFile checks the file against the sha256 digest in the
+// hash file, written by sha256sum alongside bundle binaries.
+func checkHashFile(file, hashFile string) error {
+	b, err := ioutil.ReadFile(hashFile)
+	if err != nil {
+		return err
+	}
+	b = bytes.TrimSpace(b)
+	if i := bytes.IndexRune(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	expectedHash := make([]byte, hex.DecodedLen(len(b)))
+	if _, err := hex.Decode(expectedHash, b); err != nil {
+		return fmt.Errorf("invalid hash file %s: %v", hashFile, err)
+	}
+	if err := hashCheck(file, expectedHash, sha256.New()); err != nil {
+		return fmt.Errorf("%v for %s", err, file)
+	}
+	return nil
+}
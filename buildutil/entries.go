@@ -0,0 +1,93 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheEntry is a version stored in a filesystem build cache
+type cacheEntry struct {
+	// name is the version key for the entry
+	name string
+
+	// path is the location of the cached artifact
+	path string
+
+	// init is the location of the cached init binary
+	// for legacy versions, empty if none is cached
+	init string
+
+	// meta is the location of the metadata for the entry,
+	// empty if no metadata is stored
+	meta string
+
+	// access is the location of the access record for the
+	// entry, empty if the entry has not been accessed
+	access string
+
+	// size is the total size of all files for the entry
+	size int64
+
+	// accessed is when the entry was last put or accessed
+	accessed time.Time
+}
+
+// files returns all files which belong to the entry
+func (e cacheEntry) files() []string {
+	files := []string{e.path}
+	if e.init != "" {
+		files = append(files, e.init)
+	}
+	if e.meta != "" {
+		files = append(files, e.meta)
+	}
+	if e.access != "" {
+		files = append(files, e.access)
+	}
+	return files
+}
+
+func isInitFile(name string) bool {
+	return strings.HasPrefix(name, "dockerinit") || strings.HasSuffix(name, "-init")
+}
+
+// entries returns all versions stored in the cache
+func (bc *fsBuildCache) entries() ([]cacheEntry, error) {
+	fis, err := ioutil.ReadDir(bc.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cacheEntry
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, "tmp-") || strings.HasPrefix(name, ".") || isInitFile(name) {
+			continue
+		}
+		e := cacheEntry{
+			name:     name,
+			path:     filepath.Join(bc.root, name),
+			size:     fi.Size(),
+			accessed: fi.ModTime(),
+		}
+		if ifi, err := os.Stat(initFile(e.path)); err == nil {
+			e.init = initFile(e.path)
+			e.size += ifi.Size()
+		}
+		if mf := bc.metadataFile(name); fileExists(mf) {
+			e.meta = mf
+		}
+		if afi, err := os.Stat(bc.accessFile(name)); err == nil {
+			e.access = bc.accessFile(name)
+			if afi.ModTime().After(e.accessed) {
+				e.accessed = afi.ModTime()
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,98 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// FSOption is an option for a filesystem build cache
+type FSOption func(*fsBuildCache)
+
+// WithMaxSize limits the total size of the cached files,
+// evicting the least recently used versions when exceeded.
+func WithMaxSize(size int64) FSOption {
+	return func(bc *fsBuildCache) {
+		bc.maxSize = size
+	}
+}
+
+// WithMaxEntries limits the number of cached versions,
+// evicting the least recently used versions when exceeded.
+func WithMaxEntries(n int) FSOption {
+	return func(bc *fsBuildCache) {
+		bc.maxEntries = n
+	}
+}
+
+// WithPinned prevents the given versions from being evicted
+func WithPinned(versions ...versionutil.Version) FSOption {
+	return func(bc *fsBuildCache) {
+		for _, v := range versions {
+			bc.pinned[versionKey(v)] = struct{}{}
+		}
+	}
+}
+
+// touch records an access to the cached file. The access time is
+// kept in the metadata directory so the cached file is unchanged,
+// the cache server relies on its modification time.
+func (bc *fsBuildCache) touch(cached string) {
+	af := bc.accessFile(filepath.Base(cached))
+	now := time.Now()
+	err := os.Chtimes(af, now, now)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(af), 0755); err == nil {
+			err = ioutil.WriteFile(af, nil, 0644)
+		}
+	}
+	if err != nil {
+		logrus.Debugf("Failed to update access time of %s: %v", cached, err)
+	}
+}
+
+// evict removes the least recently used versions until the
+// cache is within its limits. Pinned versions and the version
+// with the given key are never removed.
+func (bc *fsBuildCache) evict(keep string) error {
+	if bc.maxSize <= 0 && bc.maxEntries <= 0 {
+		return nil
+	}
+	entries, err := bc.entries()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	count := len(entries)
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].accessed.Before(entries[j].accessed)
+	})
+	for _, e := range entries {
+		if (bc.maxSize <= 0 || size <= bc.maxSize) && (bc.maxEntries <= 0 || count <= bc.maxEntries) {
+			break
+		}
+		if _, ok := bc.pinned[e.name]; ok || e.name == keep {
+			continue
+		}
+		logrus.Debugf("Evicting %s from cache", e.name)
+		for _, file := range e.files() {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		size -= e.size
+		count--
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pinned := commitVersion(t, "17.06.0-dev@aaaaaa")
+	c := NewFSBuildCache(cacheDir, WithMaxEntries(2), WithPinned(pinned))
+
+	versions := []string{"17.06.0-dev@aaaaaa", "17.06.0-dev@bbbbbb", "17.06.0-dev@cccccc"}
+	for i, vs := range versions {
+		source := filepath.Join(td, vs)
+		writeFiles(t, td, map[string]string{vs: vs})
+		if err := c.PutVersion(commitVersion(t, vs), source); err != nil {
+			t.Fatal(err)
+		}
+		// Ensure distinct access times
+		past := time.Now().Add(time.Duration(i-len(versions)) * time.Minute)
+		if err := os.Chtimes(filepath.Join(cacheDir, commitVersion(t, vs).Commit), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// bbbbbb is least recently used and not pinned
+	if c.IsCached(commitVersion(t, "17.06.0-dev@bbbbbb")) {
+		t.Fatal("Expected least recently used version to be evicted")
+	}
+	for _, vs := range []string{"17.06.0-dev@aaaaaa", "17.06.0-dev@cccccc"} {
+		if !c.IsCached(commitVersion(t, vs)) {
+			t.Fatalf("Expected %s to be cached", vs)
+		}
+	}
+
+	// Installing the pinned version updates its access time,
+	// the next put evicts cccccc
+	if err := c.InstallVersion(pinned, filepath.Join(td, "target")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, td, map[string]string{"dddddd": "dddddd"})
+	if err := c.PutVersion(commitVersion(t, "17.06.0-dev@dddddd"), filepath.Join(td, "dddddd")); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsCached(commitVersion(t, "17.06.0-dev@cccccc")) {
+		t.Fatal("Expected least recently used version to be evicted")
+	}
+	if !c.IsCached(pinned) {
+		t.Fatal("Pinned version should never be evicted")
+	}
+}
+
+func TestEvictMaxSize(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir, WithMaxSize(15))
+
+	writeFiles(t, td, map[string]string{
+		"first":  "0123456789",
+		"second": "0123456789",
+	})
+	if err := c.PutVersion(commitVersion(t, "17.06.0-dev@aaaaaa"), filepath.Join(td, "first")); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(filepath.Join(cacheDir, "aaaaaa"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutVersion(commitVersion(t, "17.06.0-dev@bbbbbb"), filepath.Join(td, "second")); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsCached(commitVersion(t, "17.06.0-dev@aaaaaa")) {
+		t.Fatal("Expected version to be evicted when exceeding size")
+	}
+	if !c.IsCached(commitVersion(t, "17.06.0-dev@bbbbbb")) {
+		t.Fatal("Expected newly put version to be kept")
+	}
+}
+
+func TestTouchKeepsModTime(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	v := commitVersion(t, "17.06.0-dev@aaaaaa")
+	writeFiles(t, td, map[string]string{"aaaaaa": "aaaaaa"})
+	if err := c.PutVersion(v, filepath.Join(td, "aaaaaa")); err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(cacheDir, "aaaaaa")
+	past := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(cached, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache server memoizes digests by modification time,
+	// accessing a version must not change it
+	if err := c.InstallVersion(v, filepath.Join(td, "target")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(past) {
+		t.Fatalf("Modification time changed from %s to %s", past, fi.ModTime())
+	}
+	entries, err := newFSBuildCache(cacheDir).entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].accessed.After(past) {
+		t.Fatalf("Expected access to be recorded: %#v", entries)
+	}
+}
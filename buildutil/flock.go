@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package buildutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes an exclusive lock on the file, blocking until it
+// is released by other processes. The lock is released when the
+// file is closed.
+func flock(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
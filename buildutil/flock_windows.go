@@ -0,0 +1,9 @@
+package buildutil
+
+import "os"
+
+// flock is not supported, worktree changes are only serialized
+// within the process and retried.
+func flock(f *os.File) error {
+	return nil
+}
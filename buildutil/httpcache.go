@@ -0,0 +1,212 @@
+package buildutil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// httpBuildCache is a build cache using a remote cache server
+type httpBuildCache struct {
+	client *http.Client
+	base   *url.URL
+	token  string
+}
+
+// NewHTTPBuildCache returns a build cache which uses the cache
+// server at the given URL. Versions missing from the server are
+// downloaded by the server on install. The token is used to
+// authenticate uploads.
+func NewHTTPBuildCache(server, token string) (BuildCache, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported cache server scheme %q", u.Scheme)
+	}
+	return &httpBuildCache{
+		client: http.DefaultClient,
+		base:   u,
+		token:  token,
+	}, nil
+}
+
+func (bc *httpBuildCache) versionURL(v versionutil.Version, init bool) *url.URL {
+	u := *bc.base
+	u.Path = path.Join("/", u.Path, "versions", v.String())
+	if init {
+		u.Path = u.Path + "/init"
+	}
+	return &u
+}
+
+func (bc *httpBuildCache) location() string {
+	return bc.base.String()
+}
+
+func (bc *httpBuildCache) IsCached(v versionutil.Version) bool {
+	logrus.Debugf("Looking for cached version of %s", v)
+	resp, err := bc.client.Head(bc.versionURL(v, false).String())
+	if err != nil {
+		logrus.Errorf("Error checking cache for %s: %v", v, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (bc *httpBuildCache) PutVersion(v versionutil.Version, source string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		tf, err := ioutil.TempFile("", "dockerdevtools-bundle-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tf.Name())
+		if err := writeBundleTarball(tf, source); err != nil {
+			tf.Close()
+			return err
+		}
+		if err := tf.Close(); err != nil {
+			return err
+		}
+		return bc.upload(bc.versionURL(v, false), tf.Name())
+	}
+
+	if err := bc.upload(bc.versionURL(v, false), source); err != nil {
+		return err
+	}
+	sourceInit := initFile(source)
+	if _, err := os.Stat(sourceInit); err == nil {
+		return bc.upload(bc.versionURL(v, true), sourceInit)
+	}
+
+	return nil
+}
+
+func (bc *httpBuildCache) upload(u *url.URL, file string) error {
+	dgst, err := binaryDigest(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", u.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Docker-Content-Digest", dgst.String())
+	if bc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+bc.token)
+	}
+	resp, err := bc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// fetch copies the content at the URL into the file, verifying
+// the content against the digest returned by the server.
+func (bc *httpBuildCache) fetch(u *url.URL, file string) error {
+	resp, err := bc.client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var verifier digest.Verifier
+	if dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		verifier = dgst.Verifier()
+		w = io.MultiWriter(f, verifier)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if verifier != nil && !verifier.Verified() {
+		return fmt.Errorf("digest mismatch fetching %s", u.Redacted())
+	}
+
+	return f.Close()
+}
+
+func (bc *httpBuildCache) fetchVersion(v versionutil.Version, dir string, download bool) (string, error) {
+	u := bc.versionURL(v, false)
+	if download {
+		u.RawQuery = "download=1"
+	}
+	artifact := filepath.Join(dir, versionKey(v))
+	if err := bc.fetch(u, artifact); err == errNotFound {
+		return "", ErrNotCached
+	} else if err != nil {
+		return "", err
+	}
+	if tarball, err := isTarball(artifact); err != nil {
+		return "", err
+	} else if !tarball {
+		if err := bc.fetch(bc.versionURL(v, true), initFile(artifact)); err != nil && err != errNotFound {
+			return "", err
+		}
+	}
+	return artifact, nil
+}
+
+func (bc *httpBuildCache) fetchArtifact(v versionutil.Version, dir string) (string, error) {
+	return bc.fetchVersion(v, dir, false)
+}
+
+func (bc *httpBuildCache) InstallVersion(v versionutil.Version, target string) error {
+	td, err := ioutil.TempDir("", "dockerdevtools-install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	cached, err := bc.fetchVersion(v, td, v.Commit == "")
+	if err == ErrNotCached && v.Commit != "" {
+		return ErrCannotDownloadCommit
+	} else if err != nil {
+		return err
+	}
+
+	return installArtifact(v, cached, target)
+}
@@ -0,0 +1,198 @@
+package buildutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Key returns the key identifying the output of building the
+// sources with the options. The key is the commit of the first
+// source and a hash of each source commit, any uncommitted changes,
+// the effective build settings and the toolchain, separated by "-".
+// The toolchain is the Go version on the host or the image ID for
+// container builds. The key is suffixed with "-dirty" when any
+// source has uncommitted changes.
+func (opts BuildOptions) Key() (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	var key string
+	var dirty bool
+	for _, s := range []*SourceTree{opts.Engine, opts.CLI} {
+		if s == nil {
+			continue
+		}
+		commit, err := gitCommit(s.Dir)
+		if err != nil {
+			return "", err
+		}
+		if key == "" {
+			key = commit
+		}
+		changes, err := gitChanges(s.Dir)
+		if err != nil {
+			return "", err
+		}
+		if changes != "" {
+			dirty = true
+		}
+		fmt.Fprintf(h, "%s %s %s\n", s.ImportPath, commit, changes)
+	}
+	for _, flag := range opts.BuildFlags() {
+		fmt.Fprintln(h, flag)
+	}
+	if opts.Container != nil {
+		image, err := buildImage(opts)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "image %s\n", image)
+	} else {
+		version, err := goVersion(opts.Env)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "go %s\n", version)
+	}
+
+	key += "-" + hex.EncodeToString(h.Sum(nil))[:12]
+	if dirty {
+		key += "-dirty"
+	}
+	return key, nil
+}
+
+// Version returns the version of the build, the version from the
+// VERSION file of the first source with the build key as the commit.
+// Sources without a VERSION file use the latest tag reachable from
+// the checked out commit. The version is used as the key for the
+// build in a build cache.
+func (opts BuildOptions) Version() (versionutil.Version, error) {
+	source := opts.Engine
+	if source == nil {
+		source = opts.CLI
+	}
+	if source == nil {
+		return versionutil.Version{}, fmt.Errorf("no source to build")
+	}
+	var name string
+	b, err := ioutil.ReadFile(filepath.Join(source.Dir, "VERSION"))
+	if err == nil {
+		name = strings.TrimSpace(string(b))
+	} else if os.IsNotExist(err) {
+		tag, err := git(source.Dir, "describe", "--tags", "--abbrev=0")
+		if err != nil {
+			return versionutil.Version{}, fmt.Errorf("no VERSION file or tag in %s: %v", source.Dir, err)
+		}
+		name = strings.TrimPrefix(tag, "v")
+	} else {
+		return versionutil.Version{}, err
+	}
+	v, err := versionutil.ParseVersion(name)
+	if err != nil {
+		return versionutil.Version{}, fmt.Errorf("invalid version %q in %s: %v", name, source.Dir, err)
+	}
+	if v.Commit, err = opts.Key(); err != nil {
+		return versionutil.Version{}, err
+	}
+	return v, nil
+}
+
+// CachedBuild builds the sources and puts the binaries in the
+// cache as the build version. The build is skipped when the cache
+// already holds the version, the second return value is set when
+// the cached build was used. Builds which produce no binaries
+// cannot be cached.
+func CachedBuild(c BuildCache, opts BuildOptions) (versionutil.Version, bool, error) {
+	if !opts.BuildsBinaries() {
+		return versionutil.Version{}, false, fmt.Errorf("bundles %s build no binaries to cache", strings.Join(opts.Bundles, ","))
+	}
+	v, err := opts.Version()
+	if err != nil {
+		return versionutil.Version{}, false, err
+	}
+	if c.IsCached(v) {
+		logrus.Infof("Found cached build %s", v)
+		return v, true, nil
+	}
+
+	result, err := BuildSource(opts)
+	if err != nil {
+		return versionutil.Version{}, false, err
+	}
+	if err := PutBuild(c, v, result.Binaries, result.Info); err != nil {
+		return versionutil.Version{}, false, fmt.Errorf("error caching build: %v", err)
+	}
+
+	return v, false, nil
+}
+
+// gitDirty returns whether the checkout in dir has changes
+// which are not committed, including untracked files.
+func gitDirty(dir string) (bool, error) {
+	cmd := exec.Command("git", "status", "--porcelain", "--untracked-files=all")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("error getting git status for %s: %v", dir, err)
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// gitChanges returns a hash of the uncommitted changes and
+// untracked files in dir, empty when there are no changes.
+func gitChanges(dir string) (string, error) {
+	if dirty, err := gitDirty(dir); err != nil || !dirty {
+		return "", err
+	}
+
+	h := sha256.New()
+	cmd := exec.Command("git", "diff", "HEAD", "--binary")
+	cmd.Dir = dir
+	cmd.Stdout = h
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error getting git diff for %s: %v", dir, err)
+	}
+
+	cmd = exec.Command("git", "ls-files", "--others", "--exclude-standard", "-z")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error listing untracked files for %s: %v", dir, err)
+	}
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		if err := hashFile(h, filepath.Join(dir, string(name))); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,175 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+func TestCachedBuild(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	commit := gitInit(t, filepath.Join(td, "moby"), map[string]string{
+		"hack/make.sh":            testMakeScript,
+		"hack/make/binary-daemon": "",
+		"go.mod":                  "module github.com/docker/docker\n",
+		".gitignore":              "bundles/\n",
+		"VERSION":                 "1.0.0-dev",
+	})
+	engine, err := DetectSource(filepath.Join(td, "moby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(td, "cache"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(filepath.Join(td, "cache"))
+	opts := BuildOptions{
+		Engine: engine,
+		Dir:    filepath.Join(td, "build"),
+		Env:    []string{"PATH=" + os.Getenv("PATH")},
+		Output: ioutil.Discard,
+	}
+
+	v, cached, err := CachedBuild(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached {
+		t.Fatal("Unexpected cached build")
+	}
+	if !strings.HasPrefix(v.Commit, commit+"-") || len(v.Commit) != len(commit)+13 {
+		t.Fatalf("Unexpected build key %s for commit %s", v.Commit, commit)
+	}
+	if v.Name != "1.0.0-dev" {
+		t.Fatalf("Unexpected build version %s", v)
+	}
+	if parsed, err := versionutil.ParseVersion(v.String()); err != nil || parsed != v {
+		t.Fatalf("Build version %s does not round trip: %#v %v", v, parsed, err)
+	}
+	md, err := ReadFSMetadata(filepath.Join(td, "cache"), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Source != SourceBuild || md.Build == nil || md.Build.GitCommit != commit || md.Build.Dirty {
+		t.Fatalf("Unexpected metadata: %#v", md)
+	}
+
+	v2, cached, err := CachedBuild(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached || v2 != v {
+		t.Fatalf("Expected cached build %s, got %s (cached %t)", v, v2, cached)
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"dockerd": "dockerd " + commit + "\n",
+	})
+
+	// Changing the build settings changes the key
+	opts.Experimental = true
+	v3, cached, err := CachedBuild(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached || v3 == v {
+		t.Fatalf("Expected new build for changed settings, got %s", v3)
+	}
+
+	// Uncommitted changes change the key, each change
+	// having its own key
+	writeFiles(t, engine.Dir, map[string]string{
+		"VERSION": "1.0.1-dev",
+	})
+	dirty, err := opts.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, engine.Dir, map[string]string{
+		"untracked.go": "package docker",
+	})
+	untracked, err := opts.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirty == v3.Commit || untracked == dirty {
+		t.Fatalf("Expected keys to change with uncommitted changes: %s %s %s", v3.Commit, dirty, untracked)
+	}
+	v4, cached, err := CachedBuild(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached || v4.Commit != untracked {
+		t.Fatalf("Expected new build %s, got %s", untracked, v4)
+	}
+	if v4.Name != "1.0.1-dev" || !strings.HasSuffix(v4.Commit, "-dirty") {
+		t.Fatalf("Unexpected dirty build version %s", v4)
+	}
+	if parsed, err := versionutil.ParseVersion(v4.String()); err != nil || parsed != v4 {
+		t.Fatalf("Build version %s does not round trip: %#v %v", v4, parsed, err)
+	}
+	target = filepath.Join(td, "dirty")
+	if err := c.InstallVersion(v4, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"dockerd": "dockerd " + commit + "-dirty\n",
+	})
+	md, err = ReadFSMetadata(filepath.Join(td, "cache"), v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.Build.Dirty {
+		t.Fatalf("Expected dirty build: %#v", md.Build)
+	}
+}
+
+func TestBuildVersionFromTag(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	commit := gitInit(t, filepath.Join(td, "cli"), map[string]string{
+		"scripts/build/binary": "",
+		"go.mod":               "module github.com/docker/cli\n",
+	})
+	cli, err := DetectSource(filepath.Join(td, "cli"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := BuildOptions{
+		CLI: cli,
+		Dir: filepath.Join(td, "build"),
+	}
+	if _, err := opts.Version(); err == nil {
+		t.Fatal("Expected error without VERSION file or tag")
+	}
+
+	runGit(t, cli.Dir, "tag", "v20.10.0")
+	v, err := opts.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "20.10.0" || v.Tag != "" || !strings.HasPrefix(v.Commit, commit+"-") {
+		t.Fatalf("Unexpected build version %#v", v)
+	}
+	if parsed, err := versionutil.ParseVersion(v.String()); err != nil || parsed != v {
+		t.Fatalf("Build version %s does not round trip: %#v %v", v, parsed, err)
+	}
+}
@@ -0,0 +1,71 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// isLegacy returns whether the release is distributed as a
+// single docker binary, as versions before 1.11 were.
+func isLegacy(r versionutil.Release) bool {
+	return r.Archive == versionutil.ArchiveBinary
+}
+
+// initFile returns the path of the init binary accompanying the
+// binary at the given path. A binary named "docker" or versioned
+// as "docker-1.9.0" is accompanied by "dockerinit" or
+// "dockerinit-1.9.0", any other file such as a cached artifact
+// is accompanied by "<name>-init".
+func initFile(f string) string {
+	dir, name := filepath.Split(f)
+	switch {
+	case name == "docker":
+		name = "dockerinit"
+	case strings.HasPrefix(name, "docker-"):
+		name = "dockerinit-" + name[len("docker-"):]
+	default:
+		name = name + "-init"
+	}
+	return dir + name
+}
+
+// installLegacyDocker installs a single docker binary along with
+// its init binary when used by the release.
+func installLegacyDocker(r versionutil.Release, cached, cachedInit, target string) error {
+	if err := CopyFile(cached, target, 0755); err != nil {
+		return err
+	}
+	if !isLegacy(r) {
+		return nil
+	}
+
+	targetInit := initFile(target)
+	if r.InitBinary != "" && fileExists(cachedInit) {
+		return CopyFile(cachedInit, targetInit, 0755)
+	}
+
+	return removeStaleInit(targetInit)
+}
+
+// removeStaleInit removes an init binary left from a previous
+// install which would otherwise be picked up by the installed
+// docker binary. When the file cannot be removed it is truncated
+// since the operator may only have access to the file and not
+// the directory.
+func removeStaleInit(targetInit string) error {
+	err := os.Remove(targetInit)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	logrus.Debugf("Unable to remove %s, truncating: %v", targetInit, err)
+
+	vf, terr := os.OpenFile(targetInit, os.O_TRUNC|os.O_WRONLY, 0755)
+	if terr != nil {
+		return err
+	}
+	return vf.Close()
+}
@@ -0,0 +1,204 @@
+package buildutil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTarball(t *testing.T, file string, files map[string]string) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0755,
+			Size:     int64(len(content)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyLayouts(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Version string
+		Release string
+
+		// Files are written to the source directory
+		Files map[string]string
+
+		// Tarball is written as the source when set
+		Tarball map[string]string
+
+		// Source is the path put in the cache relative
+		// to the source directory
+		Source string
+
+		Installed map[string]string
+		StaleInit bool
+	}{
+		{
+			Name:    "StaticRelease",
+			Version: "1.6.2",
+			Release: "dockerinit",
+			Files: map[string]string{
+				"docker-1.6.2": "docker 1.6.2",
+			},
+			Source: "docker-1.6.2",
+			Installed: map[string]string{
+				"docker": "docker 1.6.2",
+			},
+		},
+		{
+			Name:    "DynamicBinary",
+			Version: "1.9.0-dev@aaaaaa",
+			Release: "dockerinit",
+			Files: map[string]string{
+				"docker-1.9.0-dev":     "docker 1.9.0-dev",
+				"dockerinit-1.9.0-dev": "dockerinit 1.9.0-dev",
+			},
+			Source: "docker-1.9.0-dev",
+			Installed: map[string]string{
+				"docker":     "docker 1.9.0-dev",
+				"dockerinit": "dockerinit 1.9.0-dev",
+			},
+		},
+		{
+			Name:    "DynamicBundle",
+			Version: "1.9.0-dev@bbbbbb",
+			Release: "dockerinit",
+			Files: map[string]string{
+				"bundles/1.9.0-dev/dynbinary/docker-1.9.0-dev":            "docker 1.9.0-dev",
+				"bundles/1.9.0-dev/dynbinary/docker-1.9.0-dev.sha256":     "39832601fd3a21848ba8601047d8e4e84293e32a8a0facf4b9181f22f1936ba5  docker-1.9.0-dev",
+				"bundles/1.9.0-dev/dynbinary/dockerinit-1.9.0-dev":        "dockerinit 1.9.0-dev",
+				"bundles/1.9.0-dev/dynbinary/dockerinit-1.9.0-dev.sha256": "4ad9324afcda7879c9f4d1c4d44506cfe08dec238c5b74487fc4a7faae4f113c  dockerinit-1.9.0-dev",
+			},
+			Source: "bundles/1.9.0-dev/dynbinary",
+			Installed: map[string]string{
+				"docker":     "docker 1.9.0-dev",
+				"dockerinit": "dockerinit 1.9.0-dev",
+			},
+		},
+		{
+			Name:    "StaticWithoutInit",
+			Version: "1.10.3",
+			Release: "static",
+			Files: map[string]string{
+				"docker-1.10.3":     "docker 1.10.3",
+				"dockerinit-1.10.3": "dockerinit 1.10.3",
+			},
+			Source: "docker-1.10.3",
+			Installed: map[string]string{
+				"docker": "docker 1.10.3",
+			},
+		},
+		{
+			Name:    "LegacyTarball",
+			Version: "1.10.3",
+			Release: "static",
+			Tarball: map[string]string{
+				"usr/local/bin/docker": "docker 1.10.3",
+			},
+			Source: "docker-1.10.3.tgz",
+			Installed: map[string]string{
+				"docker": "docker 1.10.3",
+			},
+		},
+		{
+			Name:    "MultiBinaryTarball",
+			Version: "1.11.0-rc1",
+			Release: "multi-binary",
+			Tarball: map[string]string{
+				"docker/docker":            "docker 1.11.0-rc1",
+				"docker/docker-containerd": "containerd",
+			},
+			Source: "docker-1.11.0-rc1.tgz",
+			Installed: map[string]string{
+				"docker":            "docker 1.11.0-rc1",
+				"docker-containerd": "containerd",
+			},
+			StaleInit: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			td := tempDir(t)
+			defer os.RemoveAll(td)
+
+			v := commitVersion(t, tc.Version)
+			if release := v.Release(); release.Name != tc.Release {
+				t.Fatalf("Expected release %s, got %s", tc.Release, release.Name)
+			}
+
+			sourceDir := filepath.Join(td, "source")
+			if err := os.Mkdir(sourceDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, sourceDir, tc.Files)
+			if tc.Tarball != nil {
+				writeTestTarball(t, filepath.Join(sourceDir, tc.Source), tc.Tarball)
+			}
+
+			cacheDir := filepath.Join(td, "cache")
+			if err := os.Mkdir(cacheDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			c := NewFSBuildCache(cacheDir)
+			if err := c.PutVersion(v, filepath.Join(sourceDir, tc.Source)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Init binary from a previous install
+			target := filepath.Join(td, "target")
+			writeFiles(t, target, map[string]string{
+				"dockerinit": "stale",
+			})
+			if err := c.InstallVersion(v, target); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, target, tc.Installed)
+			if _, ok := tc.Installed["dockerinit"]; !ok {
+				_, err := os.Stat(filepath.Join(target, "dockerinit"))
+				if tc.StaleInit && err != nil {
+					t.Fatal("Init binary should not be modified for multi-binary versions")
+				} else if !tc.StaleInit && err == nil {
+					t.Fatal("Stale init binary should be removed")
+				}
+			}
+		})
+	}
+}
+
+func TestInitFile(t *testing.T) {
+	for f, expected := range map[string]string{
+		"/bin/docker":                 "/bin/dockerinit",
+		"/bin/docker-1.9.0":           "/bin/dockerinit-1.9.0",
+		"/cache/1.9.0":                "/cache/1.9.0-init",
+		"/cache/abcdef1234":           "/cache/abcdef1234-init",
+		"/cache/dockerdevtools-cache": "/cache/dockerdevtools-cache-init",
+	} {
+		if actual := initFile(f); actual != expected {
+			t.Errorf("Unexpected init file for %s: %s, expected %s", f, actual, expected)
+		}
+	}
+}
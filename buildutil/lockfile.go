@@ -0,0 +1,267 @@
+package buildutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v2"
+)
+
+// DigestMismatchError is returned when an artifact does not
+// match the digest recorded in a lockfile
+type DigestMismatchError struct {
+	Version  versionutil.Version
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (e DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch for %s: got %s, expected %s", e.Version, e.Actual, e.Expected)
+}
+
+// Lockfile records the resolved version, download location and
+// digest of installed versions for each architecture
+type Lockfile struct {
+	Versions []LockedVersion `yaml:"versions"`
+}
+
+// LockedVersion is a resolved version installed from a manifest
+type LockedVersion struct {
+	// Constraint is the version listed in the manifest
+	// or given to install
+	Constraint string `yaml:"constraint"`
+
+	// Version is the resolved version, including any commit
+	// and architecture
+	Version string `yaml:"version"`
+
+	// Arch is the architecture of the installed binaries
+	Arch string `yaml:"arch"`
+
+	// Dir is the directory the version is installed in,
+	// relative to the manifest
+	Dir string `yaml:"dir,omitempty"`
+
+	// URL is the location the version is downloaded from,
+	// empty for builds only available from a cache. Locked
+	// installs fail when the location has changed.
+	URL string `yaml:"url,omitempty"`
+
+	// Digest is the digest of the artifact
+	Digest digest.Digest `yaml:"digest"`
+
+	// Components are the components installed with the version
+	Components []LockedComponent `yaml:"components,omitempty"`
+}
+
+// LockedComponent is a component installed with a locked version
+type LockedComponent struct {
+	// Constraint is the component version or release line
+	// given to install, empty for manifest components
+	Constraint string `yaml:"constraint,omitempty"`
+
+	Version string        `yaml:"version"`
+	URL     string        `yaml:"url,omitempty"`
+	Digest  digest.Digest `yaml:"digest"`
+}
+
+// ReadLockfile reads the lockfile
+func ReadLockfile(p string) (*Lockfile, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var l Lockfile
+	if err := yaml.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %v", p, err)
+	}
+	return &l, nil
+}
+
+// WriteLockfile writes the lockfile, replacing any existing file
+func WriteLockfile(p string, l *Lockfile) error {
+	b, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// LockfilePath returns the lockfile path for a manifest, the
+// manifest path with a ".lock" extension.
+func LockfilePath(manifest string) string {
+	return manifest[:len(manifest)-len(filepath.Ext(manifest))] + ".lock"
+}
+
+// Lookup returns the locked version for the constraint and
+// architecture, nil if the version is not locked.
+func (l *Lockfile) Lookup(constraint, arch string) *LockedVersion {
+	for i := range l.Versions {
+		if l.Versions[i].Constraint == constraint && l.Versions[i].Arch == arch {
+			return &l.Versions[i]
+		}
+	}
+	return nil
+}
+
+// Set adds the locked version, replacing the version locked
+// for the same constraint and architecture.
+func (l *Lockfile) Set(lv LockedVersion) {
+	if existing := l.Lookup(lv.Constraint, lv.Arch); existing != nil {
+		*existing = lv
+		return
+	}
+	l.Versions = append(l.Versions, lv)
+}
+
+// Component returns the locked component version, nil if
+// the component is not locked.
+func (lv *LockedVersion) Component(v versionutil.Version) *LockedComponent {
+	for i := range lv.Components {
+		if lv.Components[i].Version == v.String() {
+			return &lv.Components[i]
+		}
+	}
+	return nil
+}
+
+// ComponentConstraint returns the component locked for the
+// constraint, nil if no component was locked for it.
+func (lv *LockedVersion) ComponentConstraint(constraint string) *LockedComponent {
+	for i := range lv.Components {
+		if lv.Components[i].Constraint == constraint {
+			return &lv.Components[i]
+		}
+	}
+	return nil
+}
+
+// downloadURL returns the location the version is downloaded from,
+// empty for builds which are only available from a cache.
+func downloadURL(v versionutil.Version) string {
+	if v.Commit != "" {
+		return ""
+	}
+	return v.DownloadURL()
+}
+
+// checkLockedURL returns an error when the version would not be
+// downloaded from the locked location.
+func checkLockedURL(v versionutil.Version, locked string) error {
+	if url := downloadURL(v); locked != "" && url != locked {
+		return fmt.Errorf("download location of %s changed from %s to %s", v, locked, url)
+	}
+	return nil
+}
+
+// InstallLocked installs the version after checking the artifact
+// against the expected digest, returning the download location
+// and digest of the installed artifact. The version is downloaded
+// into the cache if needed. No check is done when the expected
+// digest is empty, a DigestMismatchError is returned when the
+// artifact does not match.
+func InstallLocked(c BuildCache, v versionutil.Version, target string, expected digest.Digest) (string, digest.Digest, error) {
+	if _, ok := c.(artifactFetcher); !ok {
+		return "", "", fmt.Errorf("cache does not support locked installs")
+	}
+	if !c.IsCached(v) {
+		if err := populate(c, v); err != nil {
+			return "", "", err
+		}
+	}
+
+	td, err := ioutil.TempDir("", "dockerdevtools-locked-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(td)
+
+	// Install from a copy of the artifact to ensure the
+	// installed binaries are the ones checked
+	artifact, err := fetchArtifact(c, v, td)
+	if err != nil {
+		return "", "", err
+	}
+	dgst, err := binaryDigest(artifact)
+	if err != nil {
+		return "", "", err
+	}
+	if expected != "" && dgst != expected {
+		return "", "", DigestMismatchError{
+			Version:  v,
+			Expected: expected,
+			Actual:   dgst,
+		}
+	}
+	if err := installArtifact(v, artifact, target); err != nil {
+		return "", "", err
+	}
+
+	return downloadURL(v), dgst, nil
+}
+
+// InstallLockedVersion installs the version and then each component
+// to dir, with CLI plugins installed to pluginDir. When a locked
+// version is given, every artifact must match its locked digest and
+// download location. The returned locked version records the
+// installed artifacts, the constraint and architecture are left for
+// the caller to set.
+func InstallLockedVersion(c BuildCache, v versionutil.Version, components []versionutil.Version, dir, pluginDir string, locked *LockedVersion) (LockedVersion, error) {
+	lv := LockedVersion{
+		Version: v.String(),
+	}
+	var expected digest.Digest
+	if locked != nil {
+		if err := checkLockedURL(v, locked.URL); err != nil {
+			return LockedVersion{}, err
+		}
+		for _, cv := range components {
+			if lc := locked.Component(cv); lc != nil {
+				if err := checkLockedURL(cv, lc.URL); err != nil {
+					return LockedVersion{}, err
+				}
+			}
+		}
+		expected = locked.Digest
+	}
+	var err error
+	lv.URL, lv.Digest, err = InstallLocked(c, v, dir, expected)
+	if err != nil {
+		return LockedVersion{}, err
+	}
+
+	for _, cv := range components {
+		target := dir
+		for _, plugin := range versionutil.Plugins {
+			if cv.Component == plugin {
+				target = pluginDir
+			}
+		}
+		lc := LockedComponent{
+			Version: cv.String(),
+		}
+		var expected digest.Digest
+		if locked != nil {
+			locked := locked.Component(cv)
+			if locked == nil {
+				return LockedVersion{}, fmt.Errorf("%s is not locked", lc.Version)
+			}
+			expected = locked.Digest
+		}
+		lc.URL, lc.Digest, err = InstallLocked(c, cv, target, expected)
+		if err != nil {
+			return LockedVersion{}, err
+		}
+		lv.Components = append(lv.Components, lc)
+	}
+
+	return lv, nil
+}
@@ -0,0 +1,227 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestInstallLocked(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	source := filepath.Join(td, "source")
+	for _, dir := range []string{cacheDir, source} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewFSBuildCache(cacheDir)
+	tarball := filepath.Join(source, "18.09.1.tgz")
+	writeTestTarball(t, tarball, map[string]string{
+		"docker/docker":  "docker 18.09.1",
+		"docker/dockerd": "dockerd 18.09.1",
+	})
+	v := commitVersion(t, "18.09.1")
+	if err := c.PutVersion(v, tarball); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := binaryDigest(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(td, "mismatch")
+	other := digest.FromString("other")
+	_, _, err = InstallLocked(c, v, target, other)
+	if merr, ok := err.(DigestMismatchError); !ok || merr.Expected != other || merr.Actual != expected {
+		t.Fatalf("Expected digest mismatch, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing installed on mismatch: %v", err)
+	}
+
+	target = filepath.Join(td, "match")
+	_, dgst, err := InstallLocked(c, v, target, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dgst != expected {
+		t.Fatalf("Unexpected digest %s, expected %s", dgst, expected)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker":  "docker 18.09.1",
+		"dockerd": "dockerd 18.09.1",
+	})
+}
+
+func TestLockedVersionRoundTrip(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	source := filepath.Join(td, "source")
+	for _, dir := range []string{cacheDir, source} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewFSBuildCache(cacheDir)
+	v := commitVersion(t, "18.09.1.aarch64")
+	tarball := filepath.Join(source, "18.09.1.tgz")
+	writeTestTarball(t, tarball, map[string]string{
+		"docker/docker": "docker 18.09.1",
+	})
+	if err := c.PutVersion(v, tarball); err != nil {
+		t.Fatal(err)
+	}
+	cv := commitVersion(t, "runc-1.0.0-rc6.aarch64")
+	runc := filepath.Join(source, "runc")
+	writeFiles(t, source, map[string]string{
+		"runc": "runc 1.0.0-rc6",
+	})
+	if err := c.PutVersion(cv, runc); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(td, "install")
+	lv, err := InstallLockedVersion(c, v, []versionutil.Version{cv}, dir, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockfile := filepath.Join(td, "versions.lock")
+	if err := WriteLockfile(lockfile, &Lockfile{Versions: []LockedVersion{lv}}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := ReadLockfile(lockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := &l.Versions[0]
+	if parsed := commitVersion(t, locked.Version); parsed != v {
+		t.Fatalf("Unexpected locked version %#v, expected %#v", parsed, v)
+	}
+	if locked.URL != v.DownloadURL() {
+		t.Fatalf("Unexpected locked URL %s, expected %s", locked.URL, v.DownloadURL())
+	}
+	lc := locked.Component(cv)
+	if lc == nil {
+		t.Fatalf("Expected %s to be locked: %#v", cv, locked.Components)
+	}
+	if parsed := commitVersion(t, lc.Version); parsed != cv {
+		t.Fatalf("Unexpected locked component %#v, expected %#v", parsed, cv)
+	}
+
+	if _, err := InstallLockedVersion(c, v, []versionutil.Version{cv}, filepath.Join(td, "locked"), dir, locked); err != nil {
+		t.Fatalf("Error installing from lockfile: %v", err)
+	}
+
+	lc.URL = "https://example.com/runc"
+	target := filepath.Join(td, "moved")
+	if _, err := InstallLockedVersion(c, v, []versionutil.Version{cv}, target, target, locked); err == nil {
+		t.Fatal("Expected error installing from a changed download location")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing installed for changed location: %v", err)
+	}
+}
+
+func TestLockfileSet(t *testing.T) {
+	l := &Lockfile{}
+	l.Set(LockedVersion{Constraint: "18.09", Version: "18.09.0", Arch: "x86_64"})
+	l.Set(LockedVersion{Constraint: "18.09", Version: "18.09.0", Arch: "aarch64"})
+	l.Set(LockedVersion{Constraint: "18.09", Version: "18.09.1", Arch: "x86_64"})
+	if len(l.Versions) != 2 {
+		t.Fatalf("Unexpected locked versions: %#v", l.Versions)
+	}
+	if lv := l.Lookup("18.09", "x86_64"); lv == nil || lv.Version != "18.09.1" {
+		t.Fatalf("Unexpected lookup: %#v", lv)
+	}
+	if lv := l.Lookup("19.03", "x86_64"); lv != nil {
+		t.Fatalf("Unexpected lookup: %#v", lv)
+	}
+
+	lv := LockedVersion{
+		Components: []LockedComponent{
+			{Version: "containerd-1.2.0"},
+			{Constraint: "buildx-0.10", Version: "buildx-0.10.4"},
+		},
+	}
+	if lc := lv.ComponentConstraint("buildx-0.10"); lc == nil || lc.Version != "buildx-0.10.4" {
+		t.Fatalf("Unexpected component lookup: %#v", lc)
+	}
+	if lc := lv.ComponentConstraint("buildx-0.11"); lc != nil {
+		t.Fatalf("Unexpected component lookup: %#v", lc)
+	}
+}
+
+func TestSyncLocked(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	source := filepath.Join(td, "source")
+	for _, dir := range []string{cacheDir, source} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewFSBuildCache(cacheDir)
+	tarball := filepath.Join(source, "18.09.0.tgz")
+	writeTestTarball(t, tarball, map[string]string{
+		"docker/docker": "docker 18.09.0",
+	})
+	if err := c.PutVersion(commitVersion(t, "18.09.0"), tarball); err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := binaryDigest(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(td, "project")
+	m := &Manifest{
+		Versions: []ManifestVersion{{Version: "18.09"}},
+	}
+	previous := &Lockfile{
+		Versions: []LockedVersion{{
+			Constraint: "18.09",
+			Version:    "18.09.0",
+			Arch:       versionutil.NativeArch(),
+			Dir:        "18.09.0",
+			Digest:     dgst,
+		}},
+	}
+	opts := SyncOptions{
+		Previous: previous,
+		Locked:   true,
+		ListReleases: func(string) ([]versionutil.Version, error) {
+			t.Fatal("Unexpected release listing for locked sync")
+			return nil, nil
+		},
+	}
+	l, err := Sync(c, m, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Versions) != 1 || l.Versions[0].Version != "18.09.0" || l.Versions[0].Digest != dgst {
+		t.Fatalf("Unexpected locked versions: %#v", l.Versions)
+	}
+	checkFiles(t, filepath.Join(root, "18.09.0"), map[string]string{
+		"docker": "docker 18.09.0",
+	})
+
+	previous.Versions[0].Digest = digest.FromString("other")
+	if _, err := Sync(c, m, root, opts); err == nil {
+		t.Fatal("Expected error syncing mismatched digest")
+	}
+
+	m.Versions = append(m.Versions, ManifestVersion{Version: "19.03"})
+	if _, err := Sync(c, m, root, opts); err == nil {
+		t.Fatal("Expected error syncing version missing from lockfile")
+	}
+}
@@ -0,0 +1,77 @@
+package buildutil
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest lists the versions which should be installed
+//
+//	dir: versions
+//	channel: stable
+//	arches: [x86_64]
+//	components:
+//	  runc: 1.0.0-rc6
+//	versions:
+//	- 17.06.2-ce
+//	- version: "18.09"
+//	  channel: test
+//	  components:
+//	    containerd: 1.2.5
+type Manifest struct {
+	// Dir is the directory versions are installed in,
+	// relative to the manifest.
+	Dir string `yaml:"dir"`
+
+	// Layout is the directory each version is installed in
+	// within Dir. The layout replaces "{version}" and "{arch}",
+	// the default is "{version}" for a single architecture and
+	// "{arch}/{version}" otherwise.
+	Layout string `yaml:"layout"`
+
+	// Channel is the default channel used to resolve versions
+	Channel string `yaml:"channel"`
+
+	// Arches are the default architectures to install
+	Arches []string `yaml:"arches"`
+
+	// Components are the default component versions
+	Components map[string]string `yaml:"components"`
+
+	// Versions are the versions to install
+	Versions []ManifestVersion `yaml:"versions"`
+}
+
+// ManifestVersion is a version listed in a manifest, either
+// just a version constraint or a mapping with the version and
+// settings overriding the manifest defaults.
+type ManifestVersion struct {
+	Version    string            `yaml:"version"`
+	Channel    string            `yaml:"channel"`
+	Arches     []string          `yaml:"arches"`
+	Components map[string]string `yaml:"components"`
+}
+
+// UnmarshalYAML allows a version to be given as a string
+func (mv *ManifestVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&mv.Version); err == nil {
+		return nil
+	}
+	type manifestVersion ManifestVersion
+	return unmarshal((*manifestVersion)(mv))
+}
+
+// ReadManifest reads the manifest file
+func ReadManifest(p string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", p, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,231 @@
+package buildutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/opencontainers/go-digest"
+)
+
+// metadataDir is the directory within a filesystem cache
+// which holds the metadata for each version.
+const metadataDir = ".metadata"
+
+// Sources describing how a version was added to a cache
+const (
+	SourceDownload = "download"
+	SourcePut      = "put"
+	SourceUpload   = "upload"
+	SourceBuild    = "build"
+)
+
+// BuildInfo describes the build which produced a version
+type BuildInfo struct {
+	// GitCommit is the commit the build was made from
+	GitCommit string `json:"gitCommit"`
+
+	// Dirty is set when the build included uncommitted changes
+	Dirty bool `json:"dirty"`
+
+	// GoVersion is the version of Go used for the build
+	GoVersion string `json:"goVersion,omitempty"`
+
+	// BuildFlags are the options used for the build
+	BuildFlags []string `json:"buildFlags,omitempty"`
+}
+
+// Provenance describes how a version was added to a cache
+type Provenance struct {
+	// Source is how the version was added
+	Source string `json:"source"`
+
+	// URL is the location the version was downloaded from
+	URL string `json:"url,omitempty"`
+
+	// Path is the location the version was put from, or
+	// the remote address for uploads
+	Path string `json:"path,omitempty"`
+
+	// Build describes the build for built versions
+	Build *BuildInfo `json:"build,omitempty"`
+}
+
+// Metadata is the record stored alongside a cached version
+type Metadata struct {
+	// Version is the version which was cached
+	Version string `json:"version,omitempty"`
+
+	// Digest is the digest of the cached artifact
+	Digest digest.Digest `json:"digest"`
+
+	// Size is the size of the cached artifact in bytes
+	Size int64 `json:"size"`
+
+	// InitDigest is the digest of the cached init binary
+	// for legacy versions
+	InitDigest digest.Digest `json:"initDigest,omitempty"`
+
+	// Created is when the version was added to the cache
+	Created time.Time `json:"created,omitempty"`
+
+	Provenance
+}
+
+// buildPutter is implemented by caches which can record the
+// build information for a version
+type buildPutter interface {
+	putBuild(versionutil.Version, string, BuildInfo) error
+}
+
+// PutBuild puts the build output for a version in the cache,
+// recording the build information when supported by the cache.
+func PutBuild(c BuildCache, v versionutil.Version, source string, info BuildInfo) error {
+	if bp, ok := c.(buildPutter); ok {
+		return bp.putBuild(v, source, info)
+	}
+	return c.PutVersion(v, source)
+}
+
+// provenanceCache is implemented by caches which record how each
+// version was added, allowing versions to be copied between caches
+// without losing where they came from
+type provenanceCache interface {
+	provenance(versionutil.Version) (*Provenance, error)
+	putVersion(versionutil.Version, string, Provenance) error
+}
+
+// copyVersion puts the artifact fetched from one cache into another,
+// keeping the provenance recorded by the source cache when both
+// caches support it.
+func copyVersion(from, to BuildCache, v versionutil.Version, artifact string) error {
+	fp, ok := from.(provenanceCache)
+	tp, ok2 := to.(provenanceCache)
+	if !ok || !ok2 {
+		return to.PutVersion(v, artifact)
+	}
+	p, err := fp.provenance(v)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return to.PutVersion(v, artifact)
+	}
+	return tp.putVersion(v, artifact, *p)
+}
+
+// ReadFSMetadata returns the metadata for a version in the
+// filesystem build cache at root. Nil is returned when the
+// version was cached without metadata.
+func ReadFSMetadata(root string, v versionutil.Version) (*Metadata, error) {
+	bc := newFSBuildCache(root)
+	if bc.getCached(v) == "" {
+		return nil, ErrNotCached
+	}
+	return bc.readMetadata(versionKey(v))
+}
+
+// FSMetadata returns the metadata for every version in the
+// filesystem build cache at root keyed by the cached name.
+func FSMetadata(root string) (map[string]*Metadata, error) {
+	bc := newFSBuildCache(root)
+	entries, err := bc.entries()
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]*Metadata{}
+	for _, e := range entries {
+		m, err := bc.readMetadata(e.name)
+		if err != nil {
+			return nil, err
+		}
+		all[e.name] = m
+	}
+	return all, nil
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// provenance returns how the version was added, nil when the
+// version was cached without metadata
+func (bc *fsBuildCache) provenance(v versionutil.Version) (*Provenance, error) {
+	m, err := bc.readMetadata(versionKey(v))
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return &m.Provenance, nil
+}
+
+func (bc *fsBuildCache) metadataFile(name string) string {
+	return filepath.Join(bc.root, metadataDir, name+".json")
+}
+
+// accessFile is the file whose modification time records the
+// last access of the cached version with the given name
+func (bc *fsBuildCache) accessFile(name string) string {
+	return filepath.Join(bc.root, metadataDir, name+".access")
+}
+
+// readMetadata reads the metadata for the cached version with the
+// given name, returning nil if no metadata has been stored.
+func (bc *fsBuildCache) readMetadata(name string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(bc.metadataFile(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m Metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// writeMetadata records the metadata for the cached file
+func (bc *fsBuildCache) writeMetadata(v versionutil.Version, cached string, p Provenance) error {
+	fi, err := os.Stat(cached)
+	if err != nil {
+		return err
+	}
+	dgst, err := binaryDigest(cached)
+	if err != nil {
+		return err
+	}
+	m := Metadata{
+		Version:    v.String(),
+		Digest:     dgst,
+		Size:       fi.Size(),
+		Created:    time.Now().UTC(),
+		Provenance: p,
+	}
+	if cachedInit := initFile(cached); fileExists(cachedInit) {
+		m.InitDigest, err = binaryDigest(cachedInit)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	mf := bc.metadataFile(filepath.Base(cached))
+	if err := os.MkdirAll(filepath.Dir(mf), 0755); err != nil {
+		return err
+	}
+	tmp := mf + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mf)
+}
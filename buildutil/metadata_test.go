@@ -0,0 +1,72 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataProvenance(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	bundle := filepath.Join(td, "bundle")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd": "dockerd",
+	})
+	put := commitVersion(t, "17.06.0-dev@aaaaaa")
+	if err := c.PutVersion(put, bundle); err != nil {
+		t.Fatal(err)
+	}
+	built := commitVersion(t, "17.06.0-dev@bbbbbb-dirty")
+	info := BuildInfo{
+		GitCommit:  "bbbbbb",
+		Dirty:      true,
+		GoVersion:  "go1.8.3",
+		BuildFlags: []string{"DOCKER_BUILDTAGS=exclude_graphdriver_devicemapper"},
+	}
+	if err := PutBuild(c, built, bundle, info); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadFSMetadata(cacheDir, put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source != SourcePut || m.Path != bundle || m.Build != nil {
+		t.Fatalf("Unexpected provenance for put: %#v", m.Provenance)
+	}
+	if m.Version != "17.06.0-dev@aaaaaa" {
+		t.Fatalf("Unexpected version %q", m.Version)
+	}
+	if dgst, err := binaryDigest(filepath.Join(cacheDir, "aaaaaa")); err != nil {
+		t.Fatal(err)
+	} else if m.Digest != dgst {
+		t.Fatalf("Unexpected digest %s, expected %s", m.Digest, dgst)
+	}
+	if m.Created.IsZero() {
+		t.Fatal("Missing created time")
+	}
+
+	m, err = ReadFSMetadata(cacheDir, built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Source != SourceBuild || m.Build == nil || m.Build.GitCommit != "bbbbbb" || !m.Build.Dirty || m.Build.GoVersion != "go1.8.3" {
+		t.Fatalf("Unexpected provenance for build: %#v", m.Provenance)
+	}
+
+	all, err := FSMetadata(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Unexpected metadata: %#v", all)
+	}
+}
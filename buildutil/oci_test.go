@@ -0,0 +1,130 @@
+package buildutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// testRegistry is a minimal in-memory registry which
+// requires token authentication.
+type testRegistry struct {
+	l         sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string][]byte
+	uploads   int
+	realm     string
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if req.URL.Path == "/token" {
+		if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"testtoken"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer testtoken" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="test"`, r.realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/v2/devtools/"), "/", 2)
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case parts[0] == "manifests" && req.Method == "PUT":
+		b, _ := ioutil.ReadAll(req.Body)
+		r.manifests[parts[1]] = b
+		w.WriteHeader(http.StatusCreated)
+	case parts[0] == "manifests" && req.Method == "DELETE":
+		for tag, b := range r.manifests {
+			if digest.FromBytes(b).String() == parts[1] {
+				delete(r.manifests, tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case parts[0] == "manifests":
+		b, ok := r.manifests[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ociManifestType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.Write(b)
+	case parts[0] == "blobs" && parts[1] == "uploads/" && req.Method == "POST":
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/devtools/blobs/uploads/%d", r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case parts[0] == "blobs" && strings.HasPrefix(parts[1], "uploads/") && req.Method == "PUT":
+		b, _ := ioutil.ReadAll(req.Body)
+		dgst := digest.Digest(req.URL.Query().Get("digest"))
+		if dgst != digest.FromBytes(b) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[dgst] = b
+		w.WriteHeader(http.StatusCreated)
+	case parts[0] == "blobs":
+		b, ok := r.blobs[digest.Digest(parts[1])]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOCIBuildCache(t *testing.T) {
+	r := &testRegistry{
+		blobs:     map[digest.Digest][]byte{},
+		manifests: map[string][]byte{},
+	}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	r.realm = s.URL + "/token"
+
+	c, err := NewOCIBuildCache(strings.Replace(s.URL, "http://", "http://user:pass@", 1), "devtools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBundleRoundTrip(t, c)
+	testReplaceLegacyBinary(t, c)
+
+	if _, ok := r.manifests["abcdef1234"]; !ok {
+		t.Fatal("Expected manifest tagged with commit")
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull,push"`)
+	if scheme != "Bearer" {
+		t.Fatalf("Unexpected scheme %q", scheme)
+	}
+	expected := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:samalba/my-app:pull,push",
+	}
+	for k, v := range expected {
+		if params[k] != v {
+			t.Errorf("Unexpected %s: %q, expected %q", k, params[k], v)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package buildutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// PrefetchResult is the result of prefetching a version
+type PrefetchResult struct {
+	Version versionutil.Version
+
+	// Cached is set when the version was already cached
+	Cached bool
+
+	// Err is the error populating the cache, nil on success
+	Err error
+}
+
+// ensurer is implemented by caches which can populate the
+// cache without installing the version
+type ensurer interface {
+	ensureCached(versionutil.Version) (string, error)
+}
+
+// locator is implemented by caches which can name where the
+// versions are stored
+type locator interface {
+	location() string
+}
+
+// cacheLocation returns where the cache stores versions, empty
+// when unknown.
+func cacheLocation(c BuildCache) string {
+	if l, ok := c.(locator); ok {
+		return l.location()
+	}
+	return ""
+}
+
+// prefetchGroup deduplicates in-flight fetches of a version
+// into the same cache location
+var prefetchGroup singleflight.Group
+
+// Prefetch populates the cache with each version, downloading up
+// to concurrency versions at once. Concurrent requests for the
+// same version into the same cache location, including from other
+// calls to Prefetch, share a single download. Requests into caches
+// with an unknown location are only shared within the call.
+// Versions not yet started when the context is cancelled fail with
+// the context error.
+func Prefetch(ctx context.Context, c BuildCache, versions []versionutil.Version, concurrency int) []PrefetchResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	group, prefix := new(singleflight.Group), ""
+	if loc := cacheLocation(c); loc != "" {
+		group, prefix = &prefetchGroup, loc+" "
+	}
+
+	results := make([]PrefetchResult, len(versions))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, v := range versions {
+		results[i].Version = v
+		wg.Add(1)
+		go func(r *PrefetchResult) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				r.Err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				r.Err = err
+				return
+			}
+
+			if c.IsCached(r.Version) {
+				r.Cached = true
+				return
+			}
+			_, r.Err, _ = group.Do(prefix+versionKey(r.Version), func() (interface{}, error) {
+				// A fetch for the version may have completed
+				// since it was checked
+				if c.IsCached(r.Version) {
+					return nil, nil
+				}
+				return nil, populate(c, r.Version)
+			})
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// populate downloads the version into the cache, installing to a
+// temporary directory when the cache can only be populated by
+// installing.
+func populate(c BuildCache, v versionutil.Version) error {
+	logrus.Debugf("Prefetching %s", v)
+	if e, ok := c.(ensurer); ok {
+		_, err := e.ensureCached(v)
+		return err
+	}
+
+	td, err := ioutil.TempDir("", "dockerdevtools-prefetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	return c.InstallVersion(v, td)
+}
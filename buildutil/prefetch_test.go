@@ -0,0 +1,136 @@
+package buildutil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+// countingCache counts the versions populated into the cache,
+// blocking each download until the cache has been checked the
+// total number of times.
+type countingCache struct {
+	BuildCache
+
+	l       sync.Mutex
+	cached  map[string]bool
+	fetched map[string]int
+	checked int
+	ready   chan struct{}
+	total   int
+}
+
+func (c *countingCache) IsCached(v versionutil.Version) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.checked++
+	if c.checked == c.total {
+		close(c.ready)
+	}
+	return c.cached[versionKey(v)]
+}
+
+func (c *countingCache) ensureCached(v versionutil.Version) (string, error) {
+	<-c.ready
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	key := versionKey(v)
+	c.fetched[key]++
+	if key == "1.13.1" {
+		return "", errors.New("download failed")
+	}
+	c.cached[key] = true
+	return key, nil
+}
+
+func TestPrefetch(t *testing.T) {
+	var versions []versionutil.Version
+	for _, s := range []string{"17.06.2-ce", "17.06.2-ce", "18.09.0", "17.06.2-ce", "1.13.1", "17.03.2-ce"} {
+		versions = append(versions, commitVersion(t, s))
+	}
+	c := &countingCache{
+		cached: map[string]bool{
+			"17.03.2-ce": true,
+		},
+		fetched: map[string]int{},
+		ready:   make(chan struct{}),
+		// Every version is checked, then each uncached version
+		// is checked again before its single download.
+		total: len(versions) + 3,
+	}
+
+	results := Prefetch(context.Background(), c, versions, len(versions))
+	if len(results) != len(versions) {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	for i, r := range results {
+		if r.Version != versions[i] {
+			t.Errorf("Unexpected version for result %d: %s", i, r.Version)
+		}
+		switch r.Version.String() {
+		case "1.13.1":
+			if r.Err == nil {
+				t.Errorf("Expected error prefetching %s", r.Version)
+			}
+		case "17.03.2-ce":
+			if !r.Cached || r.Err != nil {
+				t.Errorf("Expected %s to be cached: %#v", r.Version, r)
+			}
+		default:
+			if r.Cached || r.Err != nil {
+				t.Errorf("Unexpected result for %s: %#v", r.Version, r)
+			}
+		}
+	}
+	expected := map[string]int{
+		"17.06.2-ce": 1,
+		"18.09.0":    1,
+		"1.13.1":     1,
+	}
+	for key, n := range expected {
+		if c.fetched[key] != n {
+			t.Errorf("Unexpected fetch count for %s: %d, expected %d", key, c.fetched[key], n)
+		}
+	}
+}
+
+func TestPrefetchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := &countingCache{
+		cached:  map[string]bool{},
+		fetched: map[string]int{},
+		ready:   make(chan struct{}),
+	}
+	results := Prefetch(ctx, c, []versionutil.Version{commitVersion(t, "18.09.0")}, 1)
+	if results[0].Err != context.Canceled {
+		t.Fatalf("Expected cancelled error, got %v", results[0].Err)
+	}
+	if len(c.fetched) != 0 {
+		t.Fatalf("Unexpected fetches: %v", c.fetched)
+	}
+}
+
+func TestPrefetchCacheLocation(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	// Caches opened separately on the same directory share fetches
+	if l1, l2 := cacheLocation(NewFSBuildCache(td)), cacheLocation(NewFSBuildCache(td+"/")); l1 == "" || l1 != l2 {
+		t.Fatalf("Expected same location for cache directory: %q, %q", l1, l2)
+	}
+
+	tiered, err := NewTieredBuildCache(DefaultTieredPolicy, NewFSBuildCache(td), installOnlyCache{NewFSBuildCache(td)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := cacheLocation(tiered); l != "" {
+		t.Fatalf("Expected unknown location for tier without location, got %q", l)
+	}
+}
@@ -0,0 +1,139 @@
+package buildutil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// errNotFound is used when a key does not exist in a remote store
+var errNotFound = errors.New("not found")
+
+// blobStore is a remote store for cache artifacts addressed
+// by the version key.
+type blobStore interface {
+	// exists returns whether the key exists in the store
+	exists(key string) (bool, error)
+
+	// get copies the content for the key into the given file
+	get(key, file string) error
+
+	// put stores the content of the file under the key
+	put(key, file string) error
+
+	// delete removes the key from the store, keys which
+	// do not exist are ignored.
+	delete(key string) error
+
+	// location returns where the store keeps its content
+	location() string
+}
+
+// storeBuildCache is a build cache backed by a remote store,
+// artifacts are copied to temporary files for installation.
+type storeBuildCache struct {
+	store blobStore
+}
+
+func (bc *storeBuildCache) location() string {
+	return bc.store.location()
+}
+
+func (bc *storeBuildCache) IsCached(v versionutil.Version) bool {
+	logrus.Debugf("Looking for cached version of %s", v)
+	ok, err := bc.store.exists(versionKey(v))
+	if err != nil {
+		logrus.Errorf("Error checking cache for %s: %v", v, err)
+		return false
+	}
+	return ok
+}
+
+func (bc *storeBuildCache) PutVersion(v versionutil.Version, source string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	key := versionKey(v)
+	if fi.IsDir() {
+		tf, err := ioutil.TempFile("", "dockerdevtools-bundle-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tf.Name())
+		if err := writeBundleTarball(tf, source); err != nil {
+			tf.Close()
+			return err
+		}
+		if err := tf.Close(); err != nil {
+			return err
+		}
+		source = tf.Name()
+	}
+
+	if err := bc.store.put(key, source); err != nil {
+		return err
+	}
+	sourceInit := initFile(source)
+	if _, err := os.Stat(sourceInit); err == nil && !fi.IsDir() {
+		return bc.store.put(initFile(key), sourceInit)
+	}
+
+	// Remove any init binary left from a previous put, it
+	// would otherwise be installed with the new binary
+	return bc.store.delete(initFile(key))
+}
+
+func (bc *storeBuildCache) fetchArtifact(v versionutil.Version, dir string) (string, error) {
+	key := versionKey(v)
+	artifact := filepath.Join(dir, key)
+	if err := bc.store.get(key, artifact); err == errNotFound {
+		return "", ErrNotCached
+	} else if err != nil {
+		return "", err
+	}
+	if tarball, err := isTarball(artifact); err != nil {
+		return "", err
+	} else if !tarball {
+		if err := bc.store.get(initFile(key), initFile(artifact)); err != nil && err != errNotFound {
+			return "", err
+		}
+	}
+	return artifact, nil
+}
+
+func (bc *storeBuildCache) InstallVersion(v versionutil.Version, target string) error {
+	td, err := ioutil.TempDir("", "dockerdevtools-install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	cached, err := bc.fetchArtifact(v, td)
+	if err == ErrNotCached {
+		logrus.Debugf("No cached artifact, downloading")
+		cached = filepath.Join(td, versionKey(v))
+		f, err := os.Create(cached)
+		if err != nil {
+			return err
+		}
+		if err := download(v, f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := bc.store.put(versionKey(v), cached); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return installArtifact(v, cached, target)
+}
@@ -0,0 +1,321 @@
+package buildutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config is the configuration for accessing an S3-compatible
+// object store.
+type S3Config struct {
+	// Endpoint is the URL of the object store. When empty
+	// the AWS endpoint for the region is used.
+	Endpoint string
+
+	// Region is the region used for request signing
+	Region string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3ConfigFromEnv returns the S3 configuration from the
+// standard AWS environment variables.
+func S3ConfigFromEnv() S3Config {
+	config := S3Config{
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL_S3"),
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if config.Region == "" {
+		config.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return config
+}
+
+// s3Store stores artifacts as objects in an S3 bucket
+// under a common prefix.
+type s3Store struct {
+	client *http.Client
+	config S3Config
+	base   *url.URL
+	bucket string
+	prefix string
+}
+
+// NewS3BuildCache returns a build cache which stores versions
+// as objects in an S3-compatible bucket under the given prefix.
+// A custom endpoint is accessed using path style requests.
+func NewS3BuildCache(bucket, prefix string, config S3Config) (BuildCache, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	s := &s3Store{
+		client: http.DefaultClient,
+		config: config,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}
+	if config.Endpoint == "" {
+		s.base = &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, config.Region),
+		}
+	} else {
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %v", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported endpoint scheme %q", u.Scheme)
+		}
+		s.base = &url.URL{
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			Path:   path.Join("/", u.Path, bucket),
+		}
+	}
+	return &storeBuildCache{store: s}, nil
+}
+
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, s.prefix, key)
+	u.RawPath = awsURIEncode(u.Path, false)
+	return &u
+}
+
+func (s *s3Store) location() string {
+	return s.objectURL("").String()
+}
+
+func (s *s3Store) exists(key string) (bool, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(key).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, statusError(resp)
+}
+
+func (s *s3Store) get(key, file string) error {
+	req, err := http.NewRequest("GET", s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *s3Store) put(key, file string) error {
+	dgst, err := binaryDigest(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, dgst.Encoded())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	return nil
+}
+
+func (s *s3Store) delete(key string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return statusError(resp)
+}
+
+func (s *s3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	if s.config.AccessKeyID != "" {
+		s.sign(req, payloadHash, time.Now().UTC())
+	}
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 authorization to
+// the request.
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.config.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk != "authorization" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.config.Region, "s3", "aws4_request"}, "/")
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(crHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode encodes all characters other than the unreserved
+// characters, slashes are only encoded when encodeSlash is set.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
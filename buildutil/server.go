@@ -0,0 +1,318 @@
+package buildutil
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheEntry describes a version stored in a build cache
+type CacheEntry struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+type cachedDigest struct {
+	size    int64
+	modTime time.Time
+	digest  digest.Digest
+}
+
+// cacheServer serves a filesystem build cache over HTTP
+type cacheServer struct {
+	bc    *fsBuildCache
+	token string
+
+	// writeL serializes changes to the cache
+	writeL sync.Mutex
+
+	digestL sync.Mutex
+	digests map[string]cachedDigest
+}
+
+// NewCacheServer returns an HTTP handler which serves the build
+// cache in the root directory. Versions are addressed by their
+// version string, uploads require the token as a bearer token
+// and are disabled when no token is given.
+//
+//	GET  /versions                   list cached versions
+//	HEAD /versions/<version>         check if version is cached
+//	GET  /versions/<version>         fetch the artifact, "?download=1" downloads on miss
+//	PUT  /versions/<version>         upload an artifact
+//	GET  /versions/<version>/init    fetch the legacy init binary
+//	PUT  /versions/<version>/init    upload the legacy init binary
+//	GET  /blobs/<digest>             fetch an artifact by digest
+func NewCacheServer(root, token string, opts ...FSOption) http.Handler {
+	return &cacheServer{
+		bc:      newFSBuildCache(root, opts...),
+		token:   token,
+		digests: map[string]cachedDigest{},
+	}
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("%s %s", r.Method, r.URL.Path)
+	switch {
+	case r.URL.Path == "/versions":
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.listVersions(w, r)
+	case strings.HasPrefix(r.URL.Path, "/versions/"):
+		name := strings.TrimPrefix(r.URL.Path, "/versions/")
+		var init bool
+		if strings.HasSuffix(name, "/init") {
+			name = strings.TrimSuffix(name, "/init")
+			init = true
+		}
+		v, err := versionutil.ParseVersion(name)
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case "GET", "HEAD":
+			s.getVersion(w, r, v, init)
+		case "PUT":
+			if !s.authorized(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			s.putVersion(w, r, v, init)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(r.URL.Path, "/blobs/"):
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.getBlob(w, r, strings.TrimPrefix(r.URL.Path, "/blobs/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *cacheServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(s.token)) == 1
+}
+
+// fileDigest returns the digest of the file, reusing the
+// previously computed digest when the file is unchanged.
+func (s *cacheServer) fileDigest(file string) (digest.Digest, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	s.digestL.Lock()
+	cd, ok := s.digests[file]
+	s.digestL.Unlock()
+	if ok && cd.size == fi.Size() && cd.modTime.Equal(fi.ModTime()) {
+		return cd.digest, nil
+	}
+
+	dgst, err := binaryDigest(file)
+	if err != nil {
+		return "", err
+	}
+	s.digestL.Lock()
+	s.digests[file] = cachedDigest{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		digest:  dgst,
+	}
+	s.digestL.Unlock()
+
+	return dgst, nil
+}
+
+func (s *cacheServer) listVersions(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.bc.entries()
+	if err != nil {
+		logrus.Errorf("Error listing cache: %v", err)
+		http.Error(w, "error listing cache", http.StatusInternalServerError)
+		return
+	}
+	list := []CacheEntry{}
+	for _, e := range entries {
+		dgst, err := s.fileDigest(e.path)
+		if err != nil {
+			logrus.Errorf("Error getting digest for %s: %v", e.path, err)
+			continue
+		}
+		list = append(list, CacheEntry{
+			Name:   e.name,
+			Digest: dgst,
+			Size:   e.size,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *cacheServer) getVersion(w http.ResponseWriter, r *http.Request, v versionutil.Version, init bool) {
+	cached := s.bc.getCached(v)
+	if cached == "" && !init && r.Method == "GET" && r.URL.Query().Get("download") == "1" {
+		s.writeL.Lock()
+		var err error
+		cached, err = s.bc.ensureCached(v)
+		s.writeL.Unlock()
+		if err != nil {
+			logrus.Errorf("Error downloading %s: %v", v, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	if cached == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "GET" {
+		s.bc.touch(cached)
+	}
+	if init {
+		cached = initFile(cached)
+		if _, err := os.Stat(cached); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	s.serveFile(w, r, cached)
+}
+
+func (s *cacheServer) getBlob(w http.ResponseWriter, r *http.Request, ref string) {
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		http.Error(w, "invalid digest", http.StatusBadRequest)
+		return
+	}
+	entries, err := s.bc.entries()
+	if err != nil {
+		logrus.Errorf("Error listing cache: %v", err)
+		http.Error(w, "error listing cache", http.StatusInternalServerError)
+		return
+	}
+	for _, e := range entries {
+		for _, file := range []string{e.path, e.init} {
+			if file == "" {
+				continue
+			}
+			if d, err := s.fileDigest(file); err == nil && d == dgst {
+				s.serveFile(w, r, file)
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (s *cacheServer) serveFile(w http.ResponseWriter, r *http.Request, file string) {
+	dgst, err := s.fileDigest(file)
+	if err != nil {
+		logrus.Errorf("Error getting digest for %s: %v", file, err)
+		http.Error(w, "error reading cache", http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		logrus.Errorf("Error opening %s: %v", file, err)
+		http.Error(w, "error reading cache", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "error reading cache", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+func (s *cacheServer) putVersion(w http.ResponseWriter, r *http.Request, v versionutil.Version, init bool) {
+	td, err := ioutil.TempDir(s.bc.root, "tmp-")
+	if err != nil {
+		logrus.Errorf("Error creating temp dir: %v", err)
+		http.Error(w, "error writing cache", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(td)
+
+	source := filepath.Join(td, "artifact")
+	f, err := os.Create(source)
+	if err != nil {
+		http.Error(w, "error writing cache", http.StatusInternalServerError)
+		return
+	}
+	expected := digest.Digest(r.Header.Get("Docker-Content-Digest"))
+	_, err = io.Copy(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logrus.Errorf("Error receiving upload for %s: %v", v, err)
+		http.Error(w, "error writing cache", http.StatusInternalServerError)
+		return
+	}
+	if expected != "" {
+		if dgst, err := binaryDigest(source); err != nil || dgst != expected {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.writeL.Lock()
+	defer s.writeL.Unlock()
+	if init {
+		cached := s.bc.getCached(v)
+		if cached == "" {
+			http.Error(w, "version must be uploaded before init binary", http.StatusConflict)
+			return
+		}
+		p := Provenance{
+			Source: SourceUpload,
+			Path:   r.RemoteAddr,
+		}
+		if m, _ := s.bc.readMetadata(versionKey(v)); m != nil {
+			p = m.Provenance
+		}
+		err = CopyFile(source, initFile(cached), 0755)
+		if err == nil {
+			err = s.bc.writeMetadata(v, cached, p)
+		}
+	} else {
+		err = s.bc.putVersion(v, source, Provenance{
+			Source: SourceUpload,
+			Path:   r.RemoteAddr,
+		})
+	}
+	if err != nil {
+		logrus.Errorf("Error putting %s: %v", v, err)
+		http.Error(w, "error writing cache", http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Stored %s", v)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,104 @@
+package buildutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheServer(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	s := httptest.NewServer(NewCacheServer(td, "secret"))
+	defer s.Close()
+
+	unauthorized, err := NewHTTPBuildCache(s.URL, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(td, "tmp-bundle")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd": "dockerd",
+	})
+	if err := unauthorized.PutVersion(commitVersion(t, "17.06.0-dev@abcdef1234"), bundle); err == nil {
+		t.Fatal("Expected unauthorized put to fail")
+	}
+
+	c, err := NewHTTPBuildCache(s.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBundleRoundTrip(t, c)
+
+	resp, err := http.Get(s.URL + "/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var entries []CacheEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "abcdef1234" {
+		t.Fatalf("Unexpected entries: %#v", entries)
+	}
+
+	resp, err = http.Get(s.URL + "/blobs/" + entries[0].Digest.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || int64(len(b)) != entries[0].Size {
+		t.Fatalf("Unexpected blob response %s with %d bytes", resp.Status, len(b))
+	}
+
+	testReplaceLegacyBinary(t, c)
+}
+
+func TestCacheServerCommitVersion(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	s := httptest.NewServer(NewCacheServer(filepath.Join(td, "cache"), "secret"))
+	defer s.Close()
+	if err := os.Mkdir(filepath.Join(td, "cache"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewHTTPBuildCache(s.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An untagged build of a commit must not replace the release
+	versions := map[string]string{
+		"18.09.0":            "release",
+		"18.09.0@abcdef1234": "build",
+	}
+	for name, content := range versions {
+		bundle := filepath.Join(td, content)
+		writeFiles(t, bundle, map[string]string{
+			"dockerd": content,
+		})
+		if err := c.PutVersion(commitVersion(t, name), bundle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range versions {
+		target := filepath.Join(td, "target-"+content)
+		if err := c.InstallVersion(commitVersion(t, name), target); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, target, map[string]string{
+			"dockerd": content,
+		})
+	}
+}
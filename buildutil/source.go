@@ -0,0 +1,113 @@
+package buildutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ImportPathEngine is the import path of the Docker engine
+	// source, the moby/moby repository.
+	ImportPathEngine = "github.com/docker/docker"
+
+	// ImportPathCLI is the import path of the docker/cli source
+	ImportPathCLI = "github.com/docker/cli"
+)
+
+// SourceTree is a Docker source checkout
+type SourceTree struct {
+	// Dir is the root of the checkout
+	Dir string
+
+	// ImportPath is the Go import path of the source
+	ImportPath string
+
+	// Modules is set when the source builds as a Go module,
+	// otherwise the source must be built from a GOPATH.
+	Modules bool
+
+	// Vendor is set when dependencies are vendored
+	Vendor bool
+}
+
+// DetectSource detects the kind of Docker source checked out in
+// dir and how it should be built. Any checkout of moby/moby or
+// docker/cli is supported, including git worktrees, whether or not
+// it is in a GOPATH.
+func DetectSource(dir string) (*SourceTree, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("source %s is not a directory", dir)
+	}
+
+	s := &SourceTree{
+		Dir:     dir,
+		Modules: fileExists(filepath.Join(dir, "go.mod")),
+		Vendor:  fileExists(filepath.Join(dir, "vendor")),
+	}
+	switch {
+	case fileExists(filepath.Join(dir, "hack", "make.sh")):
+		s.ImportPath = ImportPathEngine
+	case fileExists(filepath.Join(dir, "scripts", "build", "binary")):
+		s.ImportPath = ImportPathCLI
+	default:
+		return nil, fmt.Errorf("%s is not a Docker engine or CLI checkout", dir)
+	}
+
+	return s, nil
+}
+
+// GoEnv returns the Go environment for building the source. Module
+// builds use the vendored dependencies when present, otherwise
+// modules are disabled and the source must be built from a GOPATH.
+func (s *SourceTree) GoEnv() []string {
+	if !s.Modules {
+		return []string{"GO111MODULE=off"}
+	}
+	env := []string{"GO111MODULE=on"}
+	if s.Vendor {
+		env = append(env, "GOFLAGS=-mod=vendor")
+	}
+	return env
+}
+
+// LinkGOPATH returns a GOPATH directory under dir holding the
+// source at its import path. The source directory is returned
+// when it is already at its import path within a GOPATH.
+func (s *SourceTree) LinkGOPATH(dir string) (string, error) {
+	if gopath, ok := s.gopath(); ok {
+		return gopath, nil
+	}
+	link := filepath.Join(dir, "src", filepath.FromSlash(s.ImportPath))
+	if target, err := os.Readlink(link); err == nil && target == s.Dir {
+		// Reusing a previous build directory
+		return dir, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(link); err != nil {
+		return "", err
+	}
+	if err := os.Symlink(s.Dir, link); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// gopath returns the GOPATH containing the source, if the
+// source is checked out at its import path.
+func (s *SourceTree) gopath() (string, bool) {
+	suffix := string(filepath.Separator) + filepath.Join("src", filepath.FromSlash(s.ImportPath))
+	if !strings.HasSuffix(s.Dir, suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(s.Dir, suffix), true
+}
@@ -0,0 +1,121 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectSource(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cases := []struct {
+		Name       string
+		Files      map[string]string
+		ImportPath string
+		Env        []string
+	}{
+		{
+			Name: "gopath",
+			Files: map[string]string{
+				"hack/make.sh":           "",
+				"vendor/src/pkg/file.go": "",
+			},
+			ImportPath: ImportPathEngine,
+			Env:        []string{"GO111MODULE=off"},
+		},
+		{
+			Name: "vendormod",
+			Files: map[string]string{
+				"hack/make.sh":       "",
+				"vendor.mod":         "",
+				"vendor/modules.txt": "",
+			},
+			ImportPath: ImportPathEngine,
+			Env:        []string{"GO111MODULE=off"},
+		},
+		{
+			Name: "modules",
+			Files: map[string]string{
+				"hack/make.sh":       "",
+				"go.mod":             "",
+				"vendor/modules.txt": "",
+			},
+			ImportPath: ImportPathEngine,
+			Env:        []string{"GO111MODULE=on", "GOFLAGS=-mod=vendor"},
+		},
+		{
+			Name: "cli",
+			Files: map[string]string{
+				"scripts/build/binary": "",
+				"go.mod":               "",
+			},
+			ImportPath: ImportPathCLI,
+			Env:        []string{"GO111MODULE=on"},
+		},
+	}
+	for _, tc := range cases {
+		dir := filepath.Join(td, tc.Name)
+		writeFiles(t, dir, tc.Files)
+		s, err := DetectSource(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Name, err)
+		}
+		if s.Dir != dir || s.ImportPath != tc.ImportPath {
+			t.Errorf("%s: unexpected source %#v", tc.Name, s)
+		}
+		if env := s.GoEnv(); !reflect.DeepEqual(env, tc.Env) {
+			t.Errorf("%s: unexpected env %v, expected %v", tc.Name, env, tc.Env)
+		}
+	}
+
+	writeFiles(t, filepath.Join(td, "other"), map[string]string{
+		"README.md": "",
+	})
+	if _, err := DetectSource(filepath.Join(td, "other")); err == nil {
+		t.Fatal("Expected error detecting non-Docker source")
+	}
+}
+
+func TestLinkGOPATH(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	inGoPath := filepath.Join(td, "go", "src", "github.com", "docker", "docker")
+	outside := filepath.Join(td, "moby")
+	for _, dir := range []string{inGoPath, outside} {
+		writeFiles(t, dir, map[string]string{
+			"hack/make.sh": "",
+			"VERSION":      "18.09.0-dev",
+		})
+	}
+
+	s, err := DetectSource(inGoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath, err := s.LinkGOPATH(filepath.Join(td, "link1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gopath != filepath.Join(td, "go") {
+		t.Fatalf("Unexpected GOPATH %s", gopath)
+	}
+
+	s, err = DetectSource(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath, err = s.LinkGOPATH(filepath.Join(td, "link2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gopath != filepath.Join(td, "link2") {
+		t.Fatalf("Unexpected GOPATH %s", gopath)
+	}
+	checkFiles(t, filepath.Join(gopath, "src", "github.com", "docker", "docker"), map[string]string{
+		"VERSION": "18.09.0-dev",
+	})
+}
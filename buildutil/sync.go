@@ -0,0 +1,261 @@
+package buildutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// SyncOptions configures syncing a manifest
+type SyncOptions struct {
+	// Concurrency is the number of versions downloaded
+	// at once, defaults to 4.
+	Concurrency int
+
+	// Previous is the lockfile from the previous sync, the
+	// directories of versions no longer listed are removed.
+	Previous *Lockfile
+
+	// ListReleases lists the releases from a release index,
+	// defaults to versionutil.ListReleases.
+	ListReleases func(indexURL string) ([]versionutil.Version, error)
+
+	// Locked installs the versions recorded in the previous
+	// lockfile rather than resolving the manifest constraints,
+	// refusing any artifact whose digest differs.
+	Locked bool
+}
+
+// syncTarget is a resolved version to install
+type syncTarget struct {
+	constraint string
+	version    versionutil.Version
+	components []versionutil.Version
+	arch       string
+
+	// dir is the install directory and lockedDir is the
+	// directory relative to the manifest
+	dir       string
+	lockedDir string
+
+	// locked is the lockfile entry the artifacts must match
+	locked *LockedVersion
+}
+
+// Sync installs every version listed in the manifest through the
+// build cache, each to its own directory under root. Versions are
+// prefetched into the cache in parallel before installing. Version
+// constraints are resolved to the latest release in the channel,
+// or to the previously locked version when syncing locked.
+// The returned lockfile records the resolved versions and the
+// digests of the installed artifacts.
+func Sync(c BuildCache, m *Manifest, root string, opts SyncOptions) (*Lockfile, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.ListReleases == nil {
+		opts.ListReleases = versionutil.ListReleases
+	}
+
+	var locked *Lockfile
+	if opts.Locked {
+		if opts.Previous == nil {
+			return nil, errors.New("no lockfile to sync locked versions from")
+		}
+		locked = opts.Previous
+	}
+
+	targets, err := resolveManifest(m, root, opts.ListReleases, locked)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []versionutil.Version
+	for _, t := range targets {
+		versions = append(versions, t.version)
+		versions = append(versions, t.components...)
+	}
+	for _, r := range Prefetch(context.Background(), c, versions, opts.Concurrency) {
+		if r.Err != nil {
+			return nil, fmt.Errorf("error fetching %s: %v", r.Version, r.Err)
+		}
+	}
+
+	l := &Lockfile{}
+	for _, t := range targets {
+		lv, err := installTarget(c, t)
+		if err != nil {
+			return nil, fmt.Errorf("error installing %s: %v", t.version, err)
+		}
+		l.Versions = append(l.Versions, lv)
+	}
+
+	if opts.Previous != nil {
+		if err := removeUnlisted(opts.Previous, l, root); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// resolveManifest resolves the constraints for each version
+// and architecture listed in the manifest. When a lockfile is
+// given, versions are taken from the lockfile and every version
+// and component must be locked.
+func resolveManifest(m *Manifest, root string, listReleases func(string) ([]versionutil.Version, error), locked *Lockfile) ([]syncTarget, error) {
+	native := versionutil.NativeArch()
+	dirs := map[string]string{}
+	releases := map[string][]versionutil.Version{}
+
+	var targets []syncTarget
+	for _, mv := range m.Versions {
+		constraint, err := versionutil.ParseConstraint(mv.Version)
+		if err != nil {
+			return nil, err
+		}
+		channel := mv.Channel
+		if channel == "" {
+			channel = m.Channel
+		}
+		if channel == "" {
+			channel = "stable"
+		}
+		arches := mv.Arches
+		if len(arches) == 0 {
+			arches = m.Arches
+		}
+		if len(arches) == 0 {
+			arches = []string{native}
+		}
+		layout := m.Layout
+		if layout == "" {
+			layout = "{version}"
+			if len(arches) > 1 || len(m.Arches) > 1 {
+				layout = "{arch}/{version}"
+			}
+		}
+		components := map[string]string{}
+		for name, cv := range m.Components {
+			components[name] = cv
+		}
+		for name, cv := range mv.Components {
+			components[name] = cv
+		}
+
+		for _, arch := range arches {
+			var lv *LockedVersion
+			if locked != nil {
+				lv = locked.Lookup(constraint.String(), arch)
+				if lv == nil {
+					return nil, fmt.Errorf("%s for %s is not in the lockfile", constraint, arch)
+				}
+			}
+			v, ok := constraint.Exact()
+			if lv != nil {
+				v, err = versionutil.ParseVersion(lv.Version)
+				if err != nil {
+					return nil, fmt.Errorf("invalid locked version %s: %v", lv.Version, err)
+				}
+			} else if !ok {
+				indexURL := constraint.IndexURL("linux", arch, channel)
+				if indexURL == "" {
+					return nil, fmt.Errorf("cannot list releases for %s, use an exact version", constraint)
+				}
+				if _, ok := releases[indexURL]; !ok {
+					logrus.Debugf("Listing releases from %s", indexURL)
+					releases[indexURL], err = listReleases(indexURL)
+					if err != nil {
+						return nil, err
+					}
+				}
+				v, err = constraint.Resolve(releases[indexURL])
+				if err != nil {
+					return nil, fmt.Errorf("%v in %s channel for %s", err, channel, arch)
+				}
+			}
+			if arch != native {
+				v.Arch = arch
+			}
+
+			t := syncTarget{
+				constraint: constraint.String(),
+				version:    v,
+				arch:       arch,
+				locked:     lv,
+				lockedDir: filepath.Join(m.Dir, strings.NewReplacer(
+					"{version}", strings.TrimPrefix(v.Name, "v"),
+					"{arch}", arch,
+				).Replace(layout)),
+			}
+			t.dir = filepath.Join(root, t.lockedDir)
+			if other, ok := dirs[t.dir]; ok {
+				return nil, fmt.Errorf("%s and %s both install to %s, use a layout with {version} and {arch}", other, mv.Version, t.dir)
+			}
+			dirs[t.dir] = mv.Version
+
+			for name, s := range components {
+				cv, err := versionutil.ParseComponentVersion(name, s)
+				if err != nil {
+					return nil, err
+				}
+				if arch != native {
+					cv.Arch = arch
+				}
+				if lv != nil && lv.Component(cv) == nil {
+					return nil, fmt.Errorf("%s for %s %s is not in the lockfile", cv, constraint, arch)
+				}
+				t.components = append(t.components, cv)
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	return targets, nil
+}
+
+// installTarget installs the version and its components, plugins
+// are installed to the "cli-plugins" directory.
+func installTarget(c BuildCache, t syncTarget) (LockedVersion, error) {
+	logrus.Infof("Installing %s to %s", t.version, t.dir)
+	lv, err := InstallLockedVersion(c, t.version, t.components, t.dir, filepath.Join(t.dir, "cli-plugins"), t.locked)
+	if err != nil {
+		return LockedVersion{}, err
+	}
+	lv.Constraint = t.constraint
+	lv.Arch = t.arch
+	lv.Dir = t.lockedDir
+	return lv, nil
+}
+
+// removeUnlisted removes the directories of versions in the
+// previous lockfile which are not in the current lockfile.
+// Only directories within root are removed.
+func removeUnlisted(previous, current *Lockfile, root string) error {
+	listed := map[string]bool{}
+	for _, lv := range current.Versions {
+		listed[filepath.Clean(lv.Dir)] = true
+	}
+	for _, lv := range previous.Versions {
+		rel := filepath.Clean(lv.Dir)
+		if listed[rel] {
+			continue
+		}
+		dir := filepath.Join(root, rel)
+		if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			logrus.Warnf("Not removing %s outside of %s", lv.Dir, root)
+			continue
+		}
+		logrus.Infof("Removing %s (%s)", lv.Version, lv.Dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
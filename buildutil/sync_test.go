@@ -0,0 +1,159 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+func TestSync(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	source := filepath.Join(td, "source")
+	for _, dir := range []string{cacheDir, source} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewFSBuildCache(cacheDir)
+	for _, version := range []string{"17.06.2-ce", "18.09.1"} {
+		tarball := filepath.Join(source, version+".tgz")
+		writeTestTarball(t, tarball, map[string]string{
+			"docker/docker":  "docker " + version,
+			"docker/dockerd": "dockerd " + version,
+		})
+		if err := c.PutVersion(commitVersion(t, version), tarball); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, source, map[string]string{
+		"runc": "runc 1.0.0-rc6",
+	})
+	if err := c.PutVersion(commitVersion(t, "runc-1.0.0-rc6"), filepath.Join(source, "runc")); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(td, "project")
+	manifest := filepath.Join(root, "versions.yaml")
+	writeFiles(t, root, map[string]string{
+		"versions.yaml": `
+dir: versions
+versions:
+- 17.06.2-ce
+- version: "18.09"
+  components:
+    runc: 1.0.0-rc6
+`,
+	})
+	m, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []string
+	opts := SyncOptions{
+		ListReleases: func(indexURL string) ([]versionutil.Version, error) {
+			listed = append(listed, indexURL)
+			return []versionutil.Version{
+				commitVersion(t, "18.06.1-ce"),
+				commitVersion(t, "18.09.0"),
+				commitVersion(t, "18.09.1"),
+			}, nil
+		},
+	}
+	l, err := Sync(c, m, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedIndex := "https://download.docker.com/linux/static/stable/" + versionutil.NativeArch() + "/"
+	if len(listed) != 1 || listed[0] != expectedIndex {
+		t.Fatalf("Unexpected release listing: %v", listed)
+	}
+
+	checkFiles(t, filepath.Join(root, "versions", "17.06.2-ce"), map[string]string{
+		"docker":  "docker 17.06.2-ce",
+		"dockerd": "dockerd 17.06.2-ce",
+	})
+	checkFiles(t, filepath.Join(root, "versions", "18.09.1"), map[string]string{
+		"docker":  "docker 18.09.1",
+		"dockerd": "dockerd 18.09.1",
+		"runc":    "runc 1.0.0-rc6",
+	})
+
+	if len(l.Versions) != 2 {
+		t.Fatalf("Unexpected locked versions: %#v", l.Versions)
+	}
+	locked := l.Versions[1]
+	if locked.Constraint != "18.09" || locked.Version != "18.09.1" || locked.Dir != filepath.Join("versions", "18.09.1") {
+		t.Fatalf("Unexpected locked version: %#v", locked)
+	}
+	if locked.Digest == "" || locked.URL == "" {
+		t.Fatalf("Missing digest or URL: %#v", locked)
+	}
+	if len(locked.Components) != 1 || locked.Components[0].Version != "runc-1.0.0-rc6" || locked.Components[0].Digest == "" {
+		t.Fatalf("Unexpected locked components: %#v", locked.Components)
+	}
+
+	lockfile := LockfilePath(manifest)
+	if lockfile != filepath.Join(root, "versions.lock") {
+		t.Fatalf("Unexpected lockfile path %s", lockfile)
+	}
+	if err := WriteLockfile(lockfile, l); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ReadLockfile(lockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(previous, l) {
+		t.Fatalf("Lockfile does not round trip:\n%#v\n%#v", previous, l)
+	}
+
+	// Versions outside the project are never removed
+	outside := filepath.Join(td, "outside")
+	writeFiles(t, outside, map[string]string{
+		"docker": "docker",
+	})
+	previous.Versions = append(previous.Versions, LockedVersion{
+		Version: "1.13.1",
+		Dir:     "../outside",
+	})
+
+	m.Versions = m.Versions[1:]
+	opts.Previous = previous
+	l, err = Sync(c, m, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Versions) != 1 {
+		t.Fatalf("Unexpected locked versions: %#v", l.Versions)
+	}
+	if _, err := os.Stat(filepath.Join(root, "versions", "17.06.2-ce")); !os.IsNotExist(err) {
+		t.Fatalf("Expected unlisted version to be removed: %v", err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(outside, "docker")); err != nil {
+		t.Fatalf("Expected version outside project to remain: %v", err)
+	}
+	checkFiles(t, filepath.Join(root, "versions", "18.09.1"), map[string]string{
+		"docker": "docker 18.09.1",
+	})
+}
+
+func TestSyncDuplicateDir(t *testing.T) {
+	m := &Manifest{
+		Layout: "docker",
+		Versions: []ManifestVersion{
+			{Version: "17.06.2-ce"},
+			{Version: "18.09.1"},
+		},
+	}
+	if _, err := resolveManifest(m, "", nil, nil); err == nil {
+		t.Fatal("Expected error for versions installed to the same directory")
+	}
+}
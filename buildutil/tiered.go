@@ -0,0 +1,198 @@
+package buildutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// TieredPolicy configures how a tiered build cache uses
+// the tiers behind the first tier.
+type TieredPolicy struct {
+	// ReadThrough puts versions found in a lower tier into
+	// every tier above it.
+	ReadThrough bool
+
+	// WriteBack puts versions into every tier on PutVersion
+	// and after downloading, rather than only the first tier.
+	WriteBack bool
+
+	// BestEffort logs errors from tiers after the first
+	// rather than failing, allowing a shared cache to be
+	// unavailable.
+	BestEffort bool
+}
+
+// DefaultTieredPolicy reads through and writes back to all
+// tiers, ignoring errors from tiers after the first.
+var DefaultTieredPolicy = TieredPolicy{
+	ReadThrough: true,
+	WriteBack:   true,
+	BestEffort:  true,
+}
+
+// ParseTieredPolicy parses a comma separated list of policy
+// names: "read-through", "write-back", and "best-effort".
+func ParseTieredPolicy(s string) (TieredPolicy, error) {
+	var p TieredPolicy
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "read-through":
+			p.ReadThrough = true
+		case "write-back":
+			p.WriteBack = true
+		case "best-effort":
+			p.BestEffort = true
+		case "":
+		default:
+			return TieredPolicy{}, fmt.Errorf("unknown cache policy %q", name)
+		}
+	}
+	return p, nil
+}
+
+type tieredBuildCache struct {
+	tiers  []BuildCache
+	policy TieredPolicy
+}
+
+// NewTieredBuildCache returns a build cache which composes the
+// given caches, ordered from fastest to slowest. Versions are
+// looked up in each tier in order and only downloaded into the
+// first tier when not found in any tier.
+func NewTieredBuildCache(policy TieredPolicy, tiers ...BuildCache) (BuildCache, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("no cache tiers")
+	}
+	return &tieredBuildCache{
+		tiers:  tiers,
+		policy: policy,
+	}, nil
+}
+
+// lowerError handles an error from a tier after the first
+func (bc *tieredBuildCache) lowerError(err error) error {
+	if err != nil && bc.policy.BestEffort {
+		logrus.Errorf("Ignoring cache tier error: %v", err)
+		return nil
+	}
+	return err
+}
+
+// location joins the locations of the tiers, empty when the
+// location of any tier is unknown.
+func (bc *tieredBuildCache) location() string {
+	locations := make([]string, len(bc.tiers))
+	for i, c := range bc.tiers {
+		if locations[i] = cacheLocation(c); locations[i] == "" {
+			return ""
+		}
+	}
+	return strings.Join(locations, ",")
+}
+
+func (bc *tieredBuildCache) IsCached(v versionutil.Version) bool {
+	for _, c := range bc.tiers {
+		if c.IsCached(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bc *tieredBuildCache) PutVersion(v versionutil.Version, source string) error {
+	return bc.put(func(c BuildCache) error {
+		return c.PutVersion(v, source)
+	})
+}
+
+func (bc *tieredBuildCache) putBuild(v versionutil.Version, source string, info BuildInfo) error {
+	return bc.put(func(c BuildCache) error {
+		return PutBuild(c, v, source, info)
+	})
+}
+
+// put calls the put function on the first tier, and on every
+// other tier when writing back.
+func (bc *tieredBuildCache) put(putFn func(BuildCache) error) error {
+	if err := putFn(bc.tiers[0]); err != nil {
+		return err
+	}
+	if !bc.policy.WriteBack {
+		return nil
+	}
+	for _, c := range bc.tiers[1:] {
+		if err := bc.lowerError(putFn(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bc *tieredBuildCache) fetchArtifact(v versionutil.Version, dir string) (string, error) {
+	for i, c := range bc.tiers {
+		artifact, err := fetchArtifact(c, v, dir)
+		if err == ErrNotCached {
+			continue
+		} else if err != nil {
+			if i > 0 && bc.lowerError(err) == nil {
+				continue
+			}
+			return "", err
+		}
+
+		if i > 0 && bc.policy.ReadThrough {
+			logrus.Debugf("Reading %s through to upper tiers", v)
+			for j, upper := range bc.tiers[:i] {
+				err := copyVersion(c, upper, v, artifact)
+				if j > 0 {
+					err = bc.lowerError(err)
+				}
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+		return artifact, nil
+	}
+	return "", ErrNotCached
+}
+
+func (bc *tieredBuildCache) InstallVersion(v versionutil.Version, target string) error {
+	td, err := ioutil.TempDir("", "dockerdevtools-install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	artifact, err := bc.fetchArtifact(v, td)
+	if err == nil {
+		return installArtifact(v, artifact, target)
+	} else if err != ErrNotCached {
+		return err
+	}
+
+	logrus.Debugf("Version %s not found in any tier", v)
+	if err := bc.tiers[0].InstallVersion(v, target); err != nil {
+		return err
+	}
+	if !bc.policy.WriteBack || len(bc.tiers) == 1 {
+		return nil
+	}
+
+	artifact, err = fetchArtifact(bc.tiers[0], v, td)
+	if err != nil {
+		return bc.lowerError(err)
+	}
+	for _, c := range bc.tiers[1:] {
+		if err := bc.lowerError(copyVersion(bc.tiers[0], c, v, artifact)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package buildutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTieredReadThrough(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	var tiers []BuildCache
+	for _, name := range []string{"local", "shared"} {
+		dir := filepath.Join(td, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		tiers = append(tiers, NewFSBuildCache(dir))
+	}
+	c, err := NewTieredBuildCache(DefaultTieredPolicy, tiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(td, "bundle")
+	writeFiles(t, bundle, map[string]string{
+		"docker":  "docker",
+		"dockerd": "dockerd",
+	})
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if err := tiers[1].PutVersion(v, bundle); err != nil {
+		t.Fatal(err)
+	}
+	if tiers[0].IsCached(v) {
+		t.Fatal("Version should not be in first tier")
+	}
+	if !c.IsCached(v) {
+		t.Fatal("Version should be cached")
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"docker":  "docker",
+		"dockerd": "dockerd",
+	})
+	if !tiers[0].IsCached(v) {
+		t.Fatal("Version should be read through to first tier")
+	}
+	m, err := ReadFSMetadata(filepath.Join(td, "local"), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Source != SourcePut || m.Path != bundle {
+		t.Fatalf("Expected provenance of the shared tier, got %#v", m)
+	}
+}
+
+// installOnlyCache is a build cache implemented outside the
+// package, which cannot fetch artifacts.
+type installOnlyCache struct {
+	BuildCache
+}
+
+func TestTieredExternalCache(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	for _, name := range []string{"local", "shared"} {
+		if err := os.Mkdir(filepath.Join(td, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tiers := []BuildCache{
+		installOnlyCache{NewFSBuildCache(filepath.Join(td, "local"))},
+		NewFSBuildCache(filepath.Join(td, "shared")),
+	}
+	c, err := NewTieredBuildCache(DefaultTieredPolicy, tiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(td, "bundle")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd": "dockerd",
+	})
+	v := commitVersion(t, "17.06.0-dev@abcdef1234")
+	if err := tiers[1].PutVersion(v, bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(td, "target")
+	if err := c.InstallVersion(v, target); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, target, map[string]string{
+		"dockerd": "dockerd",
+	})
+	if !tiers[0].IsCached(v) {
+		t.Fatal("Version should be read through to first tier")
+	}
+}
+
+func TestTieredWriteBack(t *testing.T) {
+	for _, policy := range []TieredPolicy{{}, {WriteBack: true}} {
+		td := tempDir(t)
+		defer os.RemoveAll(td)
+
+		var tiers []BuildCache
+		for _, name := range []string{"local", "shared"} {
+			dir := filepath.Join(td, name)
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			tiers = append(tiers, NewFSBuildCache(dir))
+		}
+		c, err := NewTieredBuildCache(policy, tiers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bundle := filepath.Join(td, "bundle")
+		writeFiles(t, bundle, map[string]string{
+			"dockerd": "dockerd",
+		})
+		v := commitVersion(t, "17.06.0-dev@abcdef1234")
+		if err := c.PutVersion(v, bundle); err != nil {
+			t.Fatal(err)
+		}
+		if !tiers[0].IsCached(v) {
+			t.Fatal("Version should be in first tier")
+		}
+		if tiers[1].IsCached(v) != policy.WriteBack {
+			t.Fatalf("Unexpected cache state for second tier with %#v", policy)
+		}
+	}
+}
+
+func TestParseTieredPolicy(t *testing.T) {
+	p, err := ParseTieredPolicy("read-through, best-effort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (TieredPolicy{ReadThrough: true, BestEffort: true}); p != expected {
+		t.Fatalf("Unexpected policy %#v, expected %#v", p, expected)
+	}
+	if _, err := ParseTieredPolicy("write-around"); err == nil {
+		t.Fatal("Expected error for unknown policy")
+	}
+}
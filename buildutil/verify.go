@@ -0,0 +1,231 @@
+package buildutil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// quarantineDir is the directory within a filesystem cache
+// which holds entries which failed verification.
+const quarantineDir = ".quarantine"
+
+// VerifyResult is the result of verifying a cached version
+type VerifyResult struct {
+	// Name is the name of the cached version
+	Name string
+
+	// Err is the verification failure, nil if valid
+	Err error
+
+	// Unverified is set when no digest was stored for
+	// the version to compare against
+	Unverified bool
+
+	// Quarantined is set when the version was moved out
+	// of the cache after failing verification
+	Quarantined bool
+
+	// Repaired is set when the version was downloaded
+	// again after failing verification
+	Repaired bool
+}
+
+// VerifyFSBuildCache checks every version in the filesystem build
+// cache at root against its stored digest and validates the
+// structure of the artifact. Corrupt versions are moved to the
+// quarantine directory within the cache and, when repair is set,
+// released versions are downloaded again.
+func VerifyFSBuildCache(root string, repair bool) ([]VerifyResult, error) {
+	bc := newFSBuildCache(root)
+	entries, err := bc.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []VerifyResult
+	for _, e := range entries {
+		r := VerifyResult{
+			Name: e.name,
+		}
+		r.Unverified, r.Err = bc.verifyEntry(e)
+		if r.Err != nil {
+			logrus.Debugf("Verification of %s failed: %v", e.name, r.Err)
+			if err := bc.quarantine(e); err != nil {
+				return nil, fmt.Errorf("error quarantining %s: %v", e.name, err)
+			}
+			r.Quarantined = true
+			if repair {
+				if err := bc.repair(e); err != nil {
+					logrus.Errorf("Unable to repair %s: %v", e.name, err)
+				} else {
+					r.Repaired = true
+				}
+			}
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// verifyEntry validates the entry, returning whether a digest
+// was available to compare against.
+func (bc *fsBuildCache) verifyEntry(e cacheEntry) (bool, error) {
+	m, err := bc.readMetadata(e.name)
+	if err != nil {
+		return false, fmt.Errorf("invalid metadata: %v", err)
+	}
+	if m != nil {
+		if fi, err := os.Stat(e.path); err != nil {
+			return false, err
+		} else if fi.Size() != m.Size {
+			return false, fmt.Errorf("size mismatch: %d, expected %d", fi.Size(), m.Size)
+		}
+		if dgst, err := binaryDigest(e.path); err != nil {
+			return false, err
+		} else if dgst != m.Digest {
+			return false, fmt.Errorf("digest mismatch: %s, expected %s", dgst, m.Digest)
+		}
+		if m.InitDigest != "" {
+			if e.init == "" {
+				return false, fmt.Errorf("missing init binary")
+			}
+			if dgst, err := binaryDigest(e.init); err != nil {
+				return false, err
+			} else if dgst != m.InitDigest {
+				return false, fmt.Errorf("init digest mismatch: %s, expected %s", dgst, m.InitDigest)
+			}
+		}
+	}
+
+	tarball, err := isTarball(e.path)
+	if err != nil {
+		return false, err
+	}
+	if tarball {
+		dirs := []string{tarballRoot}
+		if v, ok := entryVersion(e, m); ok && v.Component != "" {
+			dirs = append(dirs, v.Release().BinaryDir)
+		}
+		err = checkTarball(e.path, dirs...)
+	} else {
+		err = checkELF(e.path)
+		if err == nil && e.init != "" {
+			err = checkELF(e.init)
+		}
+	}
+	return m == nil, err
+}
+
+// entryVersion returns the version stored in the entry from its
+// metadata or its name.
+func entryVersion(e cacheEntry, m *Metadata) (versionutil.Version, bool) {
+	name := e.name
+	if m != nil && m.Version != "" {
+		name = m.Version
+	}
+	v, err := versionutil.ParseVersion(name)
+	return v, err == nil
+}
+
+// checkTarball reads the full gzipped tarball to ensure it is
+// complete and contains binaries in one of the directories.
+func checkTarball(file string, dirs ...string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	var binaries int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid tar: %v", err)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return fmt.Errorf("invalid tar entry %s: %v", hdr.Name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		for _, dir := range dirs {
+			if strings.HasPrefix(strings.TrimPrefix(hdr.Name, "./"), dir+"/") {
+				binaries++
+				break
+			}
+		}
+	}
+	if err := gr.Close(); err != nil {
+		return fmt.Errorf("invalid gzip: %v", err)
+	}
+	if binaries == 0 {
+		return fmt.Errorf("no binaries in %s", strings.Join(dirs, " or "))
+	}
+	return nil
+}
+
+// checkELF ensures the file is a complete ELF executable
+func checkELF(file string) error {
+	f, err := elf.Open(file)
+	if err != nil {
+		return fmt.Errorf("invalid ELF binary: %v", err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("invalid ELF binary: unexpected type %s", f.Type)
+	}
+	return nil
+}
+
+// quarantine moves the files for the entry out of the cache
+func (bc *fsBuildCache) quarantine(e cacheEntry) error {
+	dir := filepath.Join(bc.root, quarantineDir, fmt.Sprintf("%s-%d", e.name, time.Now().Unix()))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range e.files() {
+		if err := os.Rename(file, filepath.Join(dir, filepath.Base(file))); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Quarantined %s to %s", e.name, dir)
+	return nil
+}
+
+// repair downloads a quarantined release version again
+func (bc *fsBuildCache) repair(e cacheEntry) error {
+	v, err := versionutil.ParseVersion(e.name)
+	if err != nil {
+		return fmt.Errorf("cannot download build: %v", err)
+	}
+	if versionKey(v) != e.name {
+		return fmt.Errorf("cannot determine version for %s", e.name)
+	}
+	cached, err := bc.ensureCached(v)
+	if err != nil {
+		return err
+	}
+	if _, err := bc.verifyEntry(cacheEntry{name: e.name, path: cached}); err != nil {
+		return fmt.Errorf("downloaded version invalid: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package buildutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyFSBuildCache(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	cacheDir := filepath.Join(td, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFSBuildCache(cacheDir)
+
+	// The test binary is used as a legacy ELF binary
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := commitVersion(t, "1.9.0-dev@aaaaaa")
+	if err := c.PutVersion(legacy, exe); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(td, "bundle")
+	writeFiles(t, bundle, map[string]string{
+		"dockerd": "dockerd",
+	})
+	good := commitVersion(t, "17.06.0-dev@bbbbbb")
+	if err := c.PutVersion(good, bundle); err != nil {
+		t.Fatal(err)
+	}
+	truncated := commitVersion(t, "17.06.0-dev@cccccc")
+	if err := c.PutVersion(truncated, bundle); err != nil {
+		t.Fatal(err)
+	}
+	modified := commitVersion(t, "17.06.0-dev@dddddd")
+	if err := c.PutVersion(modified, bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt entries after their digests are stored
+	if err := os.Truncate(filepath.Join(cacheDir, "cccccc"), 20); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, "dddddd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, "dddddd"), b, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyFSBuildCache(cacheDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"aaaaaa": true,
+		"bbbbbb": true,
+		"cccccc": false,
+		"dddddd": false,
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Unexpected results: %#v", results)
+	}
+	for _, r := range results {
+		if valid := r.Err == nil; valid != expected[r.Name] {
+			t.Errorf("Unexpected result for %s: %v", r.Name, r.Err)
+		}
+		if r.Quarantined == expected[r.Name] {
+			t.Errorf("Unexpected quarantine state for %s", r.Name)
+		}
+	}
+
+	if c.IsCached(truncated) || c.IsCached(modified) {
+		t.Fatal("Corrupt versions should be removed from cache")
+	}
+	if !c.IsCached(legacy) || !c.IsCached(good) {
+		t.Fatal("Valid versions should remain in cache")
+	}
+}
+
+func TestCheckELF(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkELF(exe); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(td, "truncated")
+	if err := ioutil.WriteFile(truncated, b[:len(b)/2], 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkELF(truncated); err == nil {
+		t.Fatal("Expected truncated binary to fail")
+	}
+}
@@ -0,0 +1,178 @@
+package buildutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Worktree is a temporary git worktree of a checkout, used to
+// build a ref without changing the checkout.
+type Worktree struct {
+	// Dir is the directory the ref is checked out in
+	Dir string
+
+	// Commit is the commit checked out
+	Commit string
+
+	repo string
+}
+
+// AddWorktree checks out the ref from the git repository in repo
+// to a new worktree in a temporary directory. Pull requests may be
+// given as "pr/<number>", which are always fetched from the origin
+// remote to build the latest pull request head. Many worktrees may
+// be added for the same repository at once.
+func AddWorktree(repo, ref string) (*Worktree, error) {
+	commit, err := resolveRef(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "dockerdevtools-worktree-")
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Checking out %s (%s) to %s", ref, commit, dir)
+	if err := worktreeGit(repo, "add", "--detach", dir, commit); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &Worktree{
+		Dir:    dir,
+		Commit: commit,
+		repo:   repo,
+	}, nil
+}
+
+// Remove removes the worktree directory and its
+// administrative files in the repository.
+func (w *Worktree) Remove() error {
+	if err := worktreeGit(w.repo, "remove", "--force", w.Dir); err != nil {
+		logrus.Debugf("Error removing worktree, pruning: %v", err)
+		if err := os.RemoveAll(w.Dir); err != nil {
+			return err
+		}
+		return worktreeGit(w.repo, "prune")
+	}
+	return nil
+}
+
+// repoLockFile is the lock file in the git directory which
+// serializes changes to the repository across processes
+const repoLockFile = "dockerdevtools.lock"
+
+// repoLocks serializes changes to each repository within the
+// process, git fails reading worktrees being added concurrently
+// and fetching refs being updated by another fetch.
+var repoLocks = struct {
+	sync.Mutex
+	repos map[string]*sync.Mutex
+}{
+	repos: map[string]*sync.Mutex{},
+}
+
+// lockRepo locks the repository against changes by this and other
+// processes, returning the function to unlock it.
+func lockRepo(repo string) (func(), error) {
+	repoLocks.Lock()
+	l, ok := repoLocks.repos[repo]
+	if !ok {
+		l = &sync.Mutex{}
+		repoLocks.repos[repo] = l
+	}
+	repoLocks.Unlock()
+	l.Lock()
+
+	// Worktrees share the git directory of the main checkout
+	dir, err := git(repo, "rev-parse", "--git-common-dir")
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repo, dir)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, repoLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		l.Unlock()
+		return nil, fmt.Errorf("error locking %s: %v", f.Name(), err)
+	}
+	return func() {
+		f.Close()
+		l.Unlock()
+	}, nil
+}
+
+// repoGit runs the git command changing the repository while holding
+// the repository lock, retrying failures from changes by git
+// commands run outside of dockerdevtools.
+func repoGit(repo string, args ...string) error {
+	unlock, err := lockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if _, err = git(repo, args...); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// worktreeGit runs the git worktree command for the repository
+func worktreeGit(repo string, args ...string) error {
+	return repoGit(repo, append([]string{"worktree"}, args...)...)
+}
+
+// resolveRef returns the commit for the ref, fetching the head
+// of pull requests since it changes as the pull request is updated.
+func resolveRef(repo, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "pr/") {
+		commit, err := git(repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+		if err != nil {
+			return "", fmt.Errorf("unknown ref %s", ref)
+		}
+		return commit, nil
+	}
+	// Fetch to "refs/pr/<number>" rather than relying on a
+	// locally available ref which may be outdated
+	logrus.Infof("Fetching pull request %s", strings.TrimPrefix(ref, "pr/"))
+	pull := "+refs/pull/" + strings.TrimPrefix(ref, "pr/") + "/head:refs/" + ref
+	if err := repoGit(repo, "fetch", "origin", pull); err != nil {
+		return "", err
+	}
+	return git(repo, "rev-parse", "--verify", "refs/"+ref+"^{commit}")
+}
+
+// git runs git in dir and returns the trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("git %s failed: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,106 @@
+package buildutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+
+	repo := filepath.Join(td, "moby")
+	tagged := gitInit(t, repo, map[string]string{
+		"VERSION": "17.06.0-ce",
+	})
+	runGit(t, repo, "tag", "v17.06.0-ce")
+	writeFiles(t, repo, map[string]string{
+		"VERSION": "17.07.0-dev",
+	})
+	runGit(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "bump")
+	writeFiles(t, repo, map[string]string{
+		"VERSION": "uncommitted",
+	})
+
+	// Pull requests are fetched from the origin remote
+	origin := filepath.Join(td, "origin")
+	pr := gitInit(t, origin, map[string]string{
+		"VERSION": "17.07.0-pr",
+	})
+	runGit(t, origin, "update-ref", "refs/pull/12345/head", pr)
+	runGit(t, repo, "remote", "add", "origin", origin)
+
+	var wg sync.WaitGroup
+	worktrees := make([]*Worktree, 4)
+	errs := make([]error, len(worktrees))
+	for i := range worktrees {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			worktrees[i], errs[i] = AddWorktree(repo, "v17.06.0-ce")
+		}(i)
+	}
+	wg.Wait()
+	for i, w := range worktrees {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if w.Commit != tagged {
+			t.Fatalf("Unexpected commit %s, expected %s", w.Commit, tagged)
+		}
+		checkFiles(t, w.Dir, map[string]string{
+			"VERSION": "17.06.0-ce",
+		})
+	}
+	for _, w := range worktrees {
+		if err := w.Remove(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(w.Dir); !os.IsNotExist(err) {
+			t.Fatalf("Expected worktree %s to be removed: %v", w.Dir, err)
+		}
+	}
+	checkFiles(t, repo, map[string]string{
+		"VERSION": "uncommitted",
+	})
+
+	w, err := AddWorktree(repo, "pr/12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Remove()
+	if w.Commit != pr {
+		t.Fatalf("Unexpected pull request commit %s, expected %s", w.Commit, pr)
+	}
+	checkFiles(t, w.Dir, map[string]string{
+		"VERSION": "17.07.0-pr",
+	})
+
+	// Updated pull requests are fetched again
+	writeFiles(t, origin, map[string]string{
+		"VERSION": "17.07.0-pr2",
+	})
+	runGit(t, origin, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-am", "update")
+	runGit(t, origin, "update-ref", "refs/pull/12345/head", "HEAD")
+	w, err = AddWorktree(repo, "pr/12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Remove()
+	if w.Commit == pr {
+		t.Fatalf("Expected updated pull request commit, got %s", w.Commit)
+	}
+	checkFiles(t, w.Dir, map[string]string{
+		"VERSION": "17.07.0-pr2",
+	})
+
+	if _, err := AddWorktree(repo, "v0.0.0"); err == nil {
+		t.Fatal("Expected error for unknown ref")
+	}
+}
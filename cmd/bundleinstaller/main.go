@@ -18,7 +18,7 @@ func main() {
 
 	targetDir, err := filepath.Abs(args[len(args)-1])
 	if err != nil {
-		logrus.Fatal("Error resolving target directory %s: %v", args[len(args)-1], err)
+		logrus.Fatalf("Error resolving target directory %s: %v", args[len(args)-1], err)
 	}
 	targetDir = filepath.Clean(targetDir)
 
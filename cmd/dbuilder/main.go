@@ -1,153 +1,323 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/dmcgowan/dockerdevtools/buildutil"
 )
 
-var (
-	BuildScript = "make.sh"
-	BuildType   = []string{"binary-client", "binary-daemon"}
-)
-
 func main() {
 	var buildDir string
 	var targetDir string
+	var sourceDir string
+	var cliDir string
 	var dynamic bool
+	var bundles string
+	var buildTags string
+	var ldflags string
+	var experimental bool
+	var passEnv string
+	var container bool
+	var image string
+	var docker string
+	var buildCache string
+	var force bool
+	var ref string
+	var logFile string
 	flag.StringVar(&targetDir, "t", "", "Directory to install files")
-	flag.StringVar(&buildDir, "b", "", "Directory to build files")
+	flag.StringVar(&buildDir, "b", "", "Directory to build files, defaults to a directory for the source reused between builds")
+	flag.StringVar(&sourceDir, "src", "", "Docker engine or CLI source checkout, defaults to the engine checkout in GOPATH")
+	flag.StringVar(&ref, "ref", "", "Git ref of the source to build in a temporary worktree, such as v17.06.0-ce or pr/12345")
+	flag.StringVar(&cliDir, "cli", "", "Docker CLI source checkout to build the client from")
 	flag.BoolVar(&dynamic, "dynamic", false, "Whether to build a dynamic binary")
+	flag.StringVar(&bundles, "bundles", "", "Comma separated make.sh bundles to build, such as binary-daemon,cross,test-unit")
+	flag.StringVar(&buildTags, "tags", "exclude_graphdriver_devicemapper", "Comma separated Go build tags")
+	flag.StringVar(&ldflags, "ldflags", "", "Additional Go linker flags")
+	flag.BoolVar(&experimental, "experimental", false, "Whether to build with experimental features enabled")
+	flag.StringVar(&passEnv, "env", "", "Comma separated environment variables to pass to the build, as NAME or NAME=VALUE")
+	flag.BoolVar(&container, "container", false, "Whether to build the engine in a container from the source Dockerfile")
+	flag.StringVar(&image, "image", "", "Image to build the engine in, instead of building the source Dockerfile")
+	flag.StringVar(&docker, "docker", "docker", "Docker client used for container builds")
+	flag.StringVar(&buildCache, "bc", os.Getenv("DBUILDER_BUILD_CACHE"), "Build cache to install unchanged builds from and put new builds in")
+	flag.BoolVar(&force, "force", false, "Whether to build even when the build is cached")
+	flag.StringVar(&logFile, "log", "", "File to write the build output to, defaults to build.log in the build directory")
 	flag.Parse()
-	if dynamic {
-		BuildType = []string{"dynbinary"}
-	}
 
 	if targetDir == "" {
 		targetDir = filepath.Join(os.Getenv("HOME"), ".bin")
 	}
 	if _, err := os.Stat(targetDir); os.IsNotExist(err) {
-		log.Fatal("Target directory does not exist: %s", targetDir)
+		log.Fatalf("Target directory does not exist: %s", targetDir)
 	} else if err != nil {
 		log.Fatalf("Error calling stat on target dir: %s", err)
 	}
 
+	opts := buildutil.BuildOptions{
+		Dynamic:      dynamic,
+		BuildTags:    splitList(buildTags),
+		LDFlags:      ldflags,
+		Experimental: experimental,
+		BuildEnv:     buildEnv(splitList(passEnv)),
+		LogFile:      logFile,
+	}
+	if opts.BuildTags == nil {
+		opts.BuildTags = []string{}
+	}
+	if bundles != "" {
+		opts.Bundles = splitList(bundles)
+	}
+	if container || image != "" {
+		opts.Container = &buildutil.ContainerOptions{
+			Docker: docker,
+			Image:  image,
+		}
+	}
+
+	if sourceDir == "" {
+		gopath := os.Getenv("GOPATH")
+		if gopath == "" {
+			log.Fatal("Must set -src or GOPATH to build Docker")
+		}
+		sourceDir = filepath.Join(gopath, "src", "github.com", "docker", "docker")
+	}
+	source, err := buildutil.DetectSource(sourceDir)
+	if err != nil {
+		log.Fatalf("Invalid Docker source: %s", err)
+	}
+	if ref != "" {
+		err = buildRef(&opts, source, ref, cliDir, buildDir, buildCache, force, targetDir)
+	} else {
+		err = build(&opts, source, cliDir, buildDir, source.Dir, buildCache, force, targetDir)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// buildRef builds the ref of the source in a temporary worktree.
+// The worktree is removed once built or when interrupted.
+func buildRef(opts *buildutil.BuildOptions, source *buildutil.SourceTree, ref, cliDir, buildDir, buildCache string, force bool, targetDir string) error {
+	worktree, err := buildutil.AddWorktree(source.Dir, ref)
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %s", ref, err)
+	}
+	defer func() {
+		if err := worktree.Remove(); err != nil {
+			log.Printf("Error removing worktree %s: %s", worktree.Dir, err)
+		}
+	}()
+
+	// Stop the build on a signal, the worktree is removed once
+	// the build scripts have exited
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts.Context = ctx
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Printf("Received %s, stopping build in worktree %s", sig, worktree.Dir)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	refSource, err := buildutil.DetectSource(worktree.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid Docker source at %s: %s", ref, err)
+	}
+	err = build(opts, refSource, cliDir, buildDir, source.Dir+"@"+ref, buildCache, force, targetDir)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("build of %s interrupted: %s", ref, err)
+	}
+	return err
+}
+
+// build builds the source and installs the build to the target
+// directory. Builds are installed from the build cache when given,
+// skipping the build when the build is already cached.
+func build(opts *buildutil.BuildOptions, source *buildutil.SourceTree, cliDir, buildDir, buildKey, buildCache string, force bool, targetDir string) error {
+	var err error
+	switch source.ImportPath {
+	case buildutil.ImportPathEngine:
+		opts.Engine = source
+	case buildutil.ImportPathCLI:
+		opts.CLI = source
+	}
+	if cliDir != "" {
+		if opts.CLI != nil {
+			return fmt.Errorf("source %s is already a CLI checkout", source.Dir)
+		}
+		opts.CLI, err = buildutil.DetectSource(cliDir)
+		if err != nil {
+			return fmt.Errorf("invalid CLI source: %s", err)
+		}
+		if opts.CLI.ImportPath != buildutil.ImportPathCLI {
+			return fmt.Errorf("not a CLI checkout: %s", opts.CLI.Dir)
+		}
+	}
+
 	if buildDir == "" {
-		var err error
-		buildDir, err = ioutil.TempDir("/tmp", "docker-build-")
+		buildDir, err = defaultBuildDir(buildKey)
 		if err != nil {
-			log.Fatalf("Error creating temp dir: %s", err)
+			return fmt.Errorf("error creating build dir: %s", err)
 		}
-		defer os.RemoveAll(buildDir)
 	} else if _, err := os.Stat(buildDir); os.IsNotExist(err) {
-		log.Fatalf("Build directory does not exist: %s", buildDir)
+		return fmt.Errorf("build directory does not exist: %s", buildDir)
 	} else if err != nil {
-		log.Fatalf("Error calling stat on build dir: %s", err)
+		return fmt.Errorf("error calling stat on build dir: %s", err)
 	}
-	buildGoPath := buildDir
-	buildDir = filepath.Join(buildDir, "src", "github.com", "docker", "docker")
+	opts.Dir = buildDir
 	log.Printf("Building in %s", buildDir)
-
-	packageCache := os.Getenv("DBUILDER_PACKAGE_CACHE")
-	if packageCache != "" {
-		os.Symlink(packageCache, filepath.Join(buildGoPath, "pkg"))
+	if opts.LogFile == "" {
+		opts.LogFile = filepath.Join(buildDir, buildutil.BuildLogFile)
 	}
 
-	gopath := os.Getenv("GOPATH")
-	if gopath == "" {
-		log.Fatal("Must set GOPATH to build Docker")
+	opts.Env = []string{
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+		fmt.Sprintf("HOME=%s", os.Getenv("HOME")),
 	}
-	goroot := os.Getenv("GOROOT")
-	if goroot == "" {
-		log.Fatal("Must set GOROOT to build Docker")
+	if goroot := os.Getenv("GOROOT"); goroot != "" {
+		opts.Env = append(opts.Env, fmt.Sprintf("GOROOT=%s", goroot))
 	}
 
-	dockerpath := filepath.Join(gopath, "src", "github.com", "docker", "docker")
-	if _, err := os.Stat(dockerpath); os.IsNotExist(err) {
-		log.Fatalf("Docker not found on path, go get or checkout in GOPATH at : %s", dockerpath)
+	// Builds without binaries, such as test or cross bundles,
+	// have nothing to put in or install from the cache
+	if buildCache != "" && !opts.BuildsBinaries() {
+		log.Printf("Bundles %s build no binaries, not using build cache", strings.Join(opts.Bundles, ","))
+		buildCache = ""
 	}
 
-	buildscript := filepath.Join(dockerpath, "hack", BuildScript)
-	if _, err := os.Stat(buildscript); os.IsNotExist(err) {
-		log.Fatalf("Build script not found, ensure Docker is checked out correctly and up to date: missing %s", buildscript)
+	// The version is always shown but only required as the
+	// key in the build cache
+	v, err := opts.Version()
+	if err != nil {
+		if buildCache != "" {
+			return fmt.Errorf("error getting build version: %s", err)
+		}
+		log.Printf("Building, writing output to %s (no version: %s)", opts.LogFile, err)
+	} else {
+		log.Printf("Building version %s, writing output to %s", v, opts.LogFile)
 	}
 
-	copyFile(filepath.Join(dockerpath, "VERSION"), filepath.Join(buildDir, "VERSION"))
-
-	copyFileIfExists(filepath.Join(dockerpath, "dockerinit", "dockerinit.go"), filepath.Join(buildDir, "dockerinit", "dockerinit.go"))
+	if buildCache == "" || force {
+		result, err := buildutil.BuildSource(*opts)
+		if err != nil {
+			return fmt.Errorf("build failure: %s", err)
+		}
+		log.Printf("Built with settings:\n\t%s", strings.Join(result.Info.BuildFlags, "\n\t"))
 
-	copyFileIfExists(filepath.Join(dockerpath, "dockerversion", "version_lib.go"), filepath.Join(buildDir, "dockerversion", "version_lib.go"))
-	copyFileIfExists(filepath.Join(dockerpath, "dockerversion", "useragent.go"), filepath.Join(buildDir, "dockerversion", "useragent.go"))
+		if buildCache != "" {
+			c, err := openBuildCache(buildCache)
+			if err != nil {
+				return err
+			}
+			if err := buildutil.PutBuild(c, v, result.Binaries, result.Info); err != nil {
+				return fmt.Errorf("error caching build: %s", err)
+			}
+			log.Printf("Cached build %s", v)
+		}
 
-	//git rev-parse HEAD
-	gitCmd := exec.Command("git", "rev-parse", "HEAD")
-	gitCmd.Dir = dockerpath
-	b, err := gitCmd.Output()
-	if err != nil {
-		log.Fatalf("Error getting git HEAD: %s", err)
+		if !opts.BuildsBinaries() {
+			log.Printf("Success, output left in %s", buildDir)
+			return nil
+		}
+		log.Printf("Success, copying %s to %s", result.Binaries, targetDir)
+		if err := buildutil.CopyBinaries(result.Binaries, targetDir); err != nil {
+			return fmt.Errorf("error copying binaries: %s", err)
+		}
+		return nil
 	}
-	log.Printf("Git version: %s", b)
 
-	buildCmd := exec.Command(buildscript, BuildType...)
-	buildCmd.Dir = buildDir
-	buildCmd.Env = []string{
-		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
-		fmt.Sprintf("GOPATH=%s:%s:%s", buildGoPath, filepath.Join(dockerpath, "vendor"), gopath),
-		fmt.Sprintf("GOROOT=%s", goroot),
-		fmt.Sprintf("DOCKER_GITCOMMIT=%s", strings.TrimSpace(string(b))),
-		"DOCKER_BUILDTAGS=exclude_graphdriver_devicemapper",
+	c, err := openBuildCache(buildCache)
+	if err != nil {
+		return err
 	}
-	buildCmd.Stderr = os.Stderr
-
-	out, err := buildCmd.Output()
+	var cached bool
+	v, cached, err = buildutil.CachedBuild(c, *opts)
 	if err != nil {
-		log.Fatalf("Build failure")
+		return fmt.Errorf("build failure: %s", err)
+	}
+	if cached {
+		log.Printf("Build %s is cached, skipping build", v)
+	} else {
+		log.Printf("Built and cached %s", v)
 	}
 
-	buildRegexp := regexp.MustCompile("Created binary:[[:space:]]+([[:graph:]]+)")
-
-	log.Printf("Success, copying\n%s", out)
-
-	matches := buildRegexp.FindAllSubmatch(out, 2)
-	if len(matches) == 0 || len(matches) > 2 {
-		log.Fatalf("Build failure: could not find binaries")
+	log.Printf("Installing %s to %s", v, targetDir)
+	if err := c.InstallVersion(v, targetDir); err != nil {
+		return fmt.Errorf("error installing build: %s", err)
 	}
+	return nil
+}
 
-	sourceDirs := map[string]struct{}{}
-	for i := range matches {
-		if len(matches[i]) != 2 {
-			log.Fatal("Invalid match: %#v", matches[i])
+// openBuildCache opens the build cache, creating the
+// directory for a local cache.
+func openBuildCache(location string) (buildutil.BuildCache, error) {
+	if !strings.Contains(location, "://") {
+		if err := os.MkdirAll(location, 0755); err != nil {
+			return nil, fmt.Errorf("error creating build cache: %s", err)
 		}
-		file := string(matches[i][1])
-		if !filepath.IsAbs(file) {
-			file = filepath.Join(buildDir, file)
-		}
-		sourceDirs[filepath.Dir(file)] = struct{}{}
 	}
-
-	for sourceDir := range sourceDirs {
-		log.Printf("Copying bundle directory %s to %s\n", sourceDir, targetDir)
-		buildutil.CopyBundleBinaries(sourceDir, targetDir)
+	c, err := buildutil.NewBuildCache(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build cache: %s", err)
 	}
+	return c, nil
+}
 
+// defaultBuildDir returns the build directory reused for
+// each build of the source directory or source ref, under
+// DBUILDER_BUILD_DIR or the user cache directory.
+func defaultBuildDir(source string) (string, error) {
+	root := os.Getenv("DBUILDER_BUILD_DIR")
+	if root == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(cacheDir, "dbuilder")
+	}
+	h := sha256.Sum256([]byte(source))
+	dir := filepath.Join(root, hex.EncodeToString(h[:])[:12])
+	return dir, os.MkdirAll(dir, 0755)
 }
 
-func copyFileIfExists(source, dest string) {
-	if _, err := os.Stat(source); err == nil {
-		copyFile(source, dest)
+// splitList splits a comma separated list, returning
+// nil for an empty list.
+func splitList(s string) []string {
+	var l []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			l = append(l, item)
+		}
 	}
+	return l
 }
 
-func copyFile(source, dest string) {
-	if err := buildutil.CopyFile(source, dest, 0644); err != nil {
-		log.Fatal(err)
+// buildEnv returns the environment for each variable, taking
+// the value from the current environment when not given.
+// Variables which are not set are skipped.
+func buildEnv(names []string) []string {
+	var env []string
+	for _, name := range names {
+		if strings.Contains(name, "=") {
+			env = append(env, name)
+		} else if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
 	}
+	return env
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// cacheCommands are the subcommands for managing a build
+// cache directory
+var cacheCommands = map[string]func([]string){
+	"inspect": cacheInspect,
+	"verify":  cacheVerify,
+}
+
+func cache(args []string) {
+	if len(args) == 0 {
+		logrus.Fatalf("Expecting cache command: inspect, verify")
+	}
+	cmd, ok := cacheCommands[args[0]]
+	if !ok {
+		logrus.Fatalf("Unknown cache command %q", args[0])
+	}
+	cmd(args[1:])
+}
+
+func cacheVerify(args []string) {
+	var buildCache string
+	var repair bool
+	var verbose bool
+	fs := flag.NewFlagSet("cache verify", flag.ExitOnError)
+	fs.StringVar(&buildCache, "bc", "", "Directory of the build cache to verify")
+	fs.BoolVar(&repair, "repair", false, "Download corrupt release versions again")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	if buildCache == "" {
+		logrus.Fatalf("Must provide build cache directory")
+	}
+
+	results, err := buildutil.VerifyFSBuildCache(buildCache, repair)
+	if err != nil {
+		logrus.Fatalf("Error verifying cache: %s", err)
+	}
+
+	var failed bool
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 1, ' ', 0)
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = fmt.Sprintf("corrupt: %v", r.Err)
+			if r.Repaired {
+				status += " (repaired)"
+			} else if r.Quarantined {
+				status += " (quarantined)"
+				failed = true
+			}
+		} else if r.Unverified {
+			status = "ok (no stored digest)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.Name, status)
+	}
+	tw.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func cacheInspect(args []string) {
+	var buildCache string
+	fs := flag.NewFlagSet("cache inspect", flag.ExitOnError)
+	fs.StringVar(&buildCache, "bc", "", "Directory of the build cache to inspect")
+	fs.Parse(args)
+	if buildCache == "" {
+		logrus.Fatalf("Must provide build cache directory")
+	}
+
+	var out interface{}
+	if fs.NArg() == 0 {
+		all, err := buildutil.FSMetadata(buildCache)
+		if err != nil {
+			logrus.Fatalf("Error reading cache metadata: %s", err)
+		}
+		out = all
+	} else {
+		var list []*buildutil.Metadata
+		for _, arg := range fs.Args() {
+			v, err := versionutil.ParseVersion(arg)
+			if err != nil {
+				logrus.Fatalf("Invalid version %s: %s", arg, err)
+			}
+			m, err := buildutil.ReadFSMetadata(buildCache, v)
+			if err != nil {
+				logrus.Fatalf("Error reading metadata for %s: %s", arg, err)
+			}
+			if m == nil {
+				logrus.Warnf("No metadata stored for %s", arg)
+			}
+			list = append(list, m)
+		}
+		out = list
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		logrus.Fatalf("Error writing metadata: %s", err)
+	}
+}
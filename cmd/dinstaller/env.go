@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmcgowan/dockerdevtools/daemonutil"
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+func env(args []string) {
+	var cf cacheFlags
+	var versionsDir string
+	var shell string
+	var host string
+	var verbose bool
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	cf.register(fs)
+	fs.StringVar(&versionsDir, "d", filepath.Join(os.Getenv("HOME"), ".local", "share", "dockerdevtools", "versions"), "Directory to install each version in")
+	fs.StringVar(&shell, "shell", filepath.Base(os.Getenv("SHELL")), "Shell to print exports for: bash, zsh, or fish")
+	fs.StringVar(&host, "host", "", "DOCKER_HOST to export, defaults to the socket of the daemon from setup-daemon")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	var c versionutil.Constraint
+	var err error
+	switch fs.NArg() {
+	case 0:
+		var vf string
+		c, vf, err = versionutil.FindVersionFile(".")
+		if err != nil {
+			logrus.Fatalf("Must provide version: %s", err)
+		}
+		logrus.Debugf("Using %s from %s", c, vf)
+	case 1:
+		c, err = versionutil.ParseConstraint(fs.Arg(0))
+		if err != nil {
+			logrus.Fatalf("Invalid version: %s", err)
+		}
+	default:
+		logrus.Fatalf("Can only use 1 version")
+	}
+	v, err := resolveConstraint(c)
+	if err != nil {
+		logrus.Fatalf("Error resolving %s: %s", c, err)
+	}
+
+	binDir, err := filepath.Abs(filepath.Join(versionsDir, v.String()))
+	if err != nil {
+		logrus.Fatalf("Invalid versions directory: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(binDir, "docker")); err != nil {
+		logrus.Infof("Installing %s to %s", v, binDir)
+		if err := installVersions(cf.open(), binDir, v); err != nil {
+			logrus.Fatalf("%s", err)
+		}
+	}
+
+	if host == "" {
+		host = daemonutil.DefaultHost()
+	}
+	exports := [][2]string{{"PATH", binDir}, {"DOCKER_HOST", host}}
+	if api := v.APIVersion(); api != "" {
+		exports = append(exports, [2]string{"DOCKER_API_VERSION", api})
+	}
+
+	for _, e := range exports {
+		line, err := exportLine(shell, e[0], e[1])
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		fmt.Println(line)
+	}
+}
+
+// exportLine returns the shell command to export the variable,
+// the value is prepended when exporting PATH.
+func exportLine(shell, name, value string) (string, error) {
+	switch shell {
+	case "bash", "zsh", "sh":
+		quoted := "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+		if name == "PATH" {
+			quoted = quoted + `:"$PATH"`
+		}
+		return fmt.Sprintf("export %s=%s", name, quoted), nil
+	case "fish":
+		quoted := "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+		if name == "PATH" {
+			quoted = quoted + " $PATH"
+		}
+		return fmt.Sprintf("set -gx %s %s;", name, quoted), nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, expected bash, zsh, or fish", shell)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// installLocked installs the version, components and plugins,
+// recording their digests and constraints in the lockfile. When
+// locked, the versions are taken from the lockfile without
+// resolving the plugin release lines again and each artifact
+// must match the recorded digest.
+func installLocked(c buildutil.BuildCache, lockfile string, locked bool, constraint string, v versionutil.Version, components []versionutil.Version, plugins []versionutil.Constraint, targetDir, pluginDir string) {
+	l, err := buildutil.ReadLockfile(lockfile)
+	if err != nil {
+		if locked || !os.IsNotExist(err) {
+			logrus.Fatalf("Error reading lockfile: %s", err)
+		}
+		l = &buildutil.Lockfile{}
+	}
+
+	arch := v.Arch
+	if arch == "" {
+		arch = versionutil.NativeArch()
+	}
+	// Exact component versions are their own constraint
+	var constraints []string
+	for _, cv := range components {
+		constraints = append(constraints, cv.String())
+	}
+	for _, pc := range plugins {
+		constraints = append(constraints, pc.String())
+	}
+
+	var expected *buildutil.LockedVersion
+	if locked {
+		expected = l.Lookup(constraint, arch)
+		if expected == nil {
+			logrus.Fatalf("%s for %s is not in %s", constraint, arch, lockfile)
+		}
+		if v, err = versionutil.ParseVersion(expected.Version); err != nil {
+			logrus.Fatalf("Invalid locked version %s: %s", expected.Version, err)
+		}
+		components = nil
+		for _, cs := range constraints {
+			lc := expected.ComponentConstraint(cs)
+			if lc == nil {
+				logrus.Fatalf("%s for %s %s is not in %s", cs, constraint, arch, lockfile)
+			}
+			cv, err := versionutil.ParseVersion(lc.Version)
+			if err != nil {
+				logrus.Fatalf("Invalid locked version %s: %s", lc.Version, err)
+			}
+			components = append(components, cv)
+		}
+	} else {
+		components = append(components, resolvePlugins(plugins)...)
+	}
+
+	lv, err := buildutil.InstallLockedVersion(c, v, components, targetDir, pluginDir, expected)
+	if err != nil {
+		logrus.Fatalf("Error installing %s: %s", v, err)
+	}
+	if locked {
+		return
+	}
+
+	lv.Constraint = constraint
+	lv.Arch = arch
+	for i := range lv.Components {
+		lv.Components[i].Constraint = constraints[i]
+	}
+	l.Set(lv)
+	if err := buildutil.WriteLockfile(lockfile, l); err != nil {
+		logrus.Fatalf("Error writing lockfile: %s", err)
+	}
+}
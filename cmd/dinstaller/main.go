@@ -6,34 +6,164 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/dmcgowan/dockerdevtools/buildutil"
 	"github.com/dmcgowan/dockerdevtools/versionutil"
 	"github.com/sirupsen/logrus"
 )
 
+// commands are the subcommands, installing a version is
+// the default when no command is given.
+var commands = map[string]func([]string){
+	"cache":        cache,
+	"env":          env,
+	"install":      install,
+	"prefetch":     prefetch,
+	"run":          run,
+	"serve":        serve,
+	"setup-daemon": setupDaemon,
+	"sync":         syncVersions,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+	install(os.Args[1:])
+}
+
+// limitFlags are the flags used to limit the size of
+// a build cache directory
+type limitFlags struct {
+	maxSize    string
+	maxEntries int
+	pin        string
+}
+
+func (lf *limitFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&lf.maxSize, "max-size", "", "Maximum size of cache directory, such as 500M or 10G")
+	fs.IntVar(&lf.maxEntries, "max-entries", 0, "Maximum number of versions in cache directory")
+	fs.StringVar(&lf.pin, "pin", "", "Comma separated versions never evicted from cache directory")
+}
+
+func (lf *limitFlags) options() []buildutil.FSOption {
+	var opts []buildutil.FSOption
+	if lf.maxSize != "" {
+		size, err := parseSize(lf.maxSize)
+		if err != nil {
+			logrus.Fatalf("Invalid max size: %s", err)
+		}
+		opts = append(opts, buildutil.WithMaxSize(size))
+	}
+	if lf.maxEntries > 0 {
+		opts = append(opts, buildutil.WithMaxEntries(lf.maxEntries))
+	}
+	if lf.pin != "" {
+		var pinned []versionutil.Version
+		for _, s := range strings.Split(lf.pin, ",") {
+			v, err := versionutil.ParseVersion(s)
+			if err != nil {
+				logrus.Fatalf("Invalid pinned version %s: %s", s, err)
+			}
+			pinned = append(pinned, v)
+		}
+		opts = append(opts, buildutil.WithPinned(pinned...))
+	}
+	return opts
+}
+
+// parseSize parses a size in bytes with an optional
+// K, M, or G suffix.
+func parseSize(s string) (int64, error) {
+	var multiplier int64 = 1
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// cacheFlags are the flags used to select the build cache
+type cacheFlags struct {
+	limitFlags
+	buildCache  string
+	cachePolicy string
+}
+
+func (cf *cacheFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&cf.buildCache, "bc", "", "Directory, oci://registry/repo, s3://bucket/prefix or cache server URL to cache builds, comma separated to use tiers")
+	fs.StringVar(&cf.cachePolicy, "cache-policy", "read-through,write-back,best-effort", "Policy for tiered build caches")
+	cf.limitFlags.register(fs)
+}
+
+// open opens the build cache, using a new temporary directory
+// when no build cache was given.
+func (cf *cacheFlags) open() buildutil.BuildCache {
+	if cf.buildCache == "" {
+		var err error
+		cf.buildCache, err = ioutil.TempDir("/tmp", "docker-install-")
+		if err != nil {
+			logrus.Fatalf("Error creating temp dir: %s", err)
+		}
+	}
+	c, err := openBuildCache(cf.buildCache, cf.cachePolicy, cf.options()...)
+	if err != nil {
+		logrus.Fatalf("Invalid build cache: %s", err)
+	}
+	return c
+}
+
+func install(args []string) {
 	var targetDir string
-	var buildCache string
+	var cf cacheFlags
 	var checkCache bool
 	var useFile string
+	var pluginDir string
+	var lockfile string
+	var locked bool
 	var verbose bool
-	flag.StringVar(&targetDir, "t", "", "Directory to install files")
-	flag.StringVar(&buildCache, "bc", "", "Directory to cache builds")
-	flag.BoolVar(&checkCache, "cc", false, "Whether to only do a cache check")
-	flag.BoolVar(&verbose, "v", false, "Verbose logging")
-	flag.StringVar(&useFile, "put", "", "Use the provided file instead of cache and put in cache")
-	flag.Parse()
+	var compf componentFlags
+	plugins := map[string]*string{}
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	fs.StringVar(&targetDir, "t", "", "Directory to install files")
+	cf.register(fs)
+	fs.BoolVar(&checkCache, "cc", false, "Whether to only do a cache check")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.StringVar(&useFile, "put", "", "Use the provided file or bundle directory instead of cache and put in cache")
+	compf.register(fs)
+	for _, plugin := range versionutil.Plugins {
+		plugins[plugin] = fs.String(plugin, "", fmt.Sprintf("Version or release line of the %s CLI plugin to install", plugin))
+	}
+	fs.StringVar(&pluginDir, "plugin-dir", "", "Directory to install CLI plugins")
+	fs.StringVar(&lockfile, "lockfile", "", "Lockfile to record the installed versions and digests in")
+	fs.BoolVar(&locked, "locked", false, "Only install the versions and digests recorded in the lockfile")
+	fs.Parse(args)
 	if verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
 	version := "latest"
-	if flag.NArg() > 1 {
+	if fs.NArg() > 1 {
 		logrus.Fatalf("Can only install 1 version")
 	}
-	if flag.NArg() == 1 {
-		version = flag.Arg(0)
+	if fs.NArg() == 1 {
+		version = fs.Arg(0)
 	}
 	if version == "latest" {
 		// TODO: Support downloading from
@@ -43,23 +173,29 @@ func main() {
 	if targetDir == "" {
 		targetDir = filepath.Join(os.Getenv("HOME"), ".bin")
 	}
-	if buildCache == "" {
-		var err error
-		buildCache, err = ioutil.TempDir("/tmp", "docker-install-")
-		if err != nil {
-			logrus.Fatalf("Error creating temp dir: %s", err)
-		}
+	if pluginDir == "" {
+		pluginDir = defaultPluginDir()
+	}
+	if locked && lockfile == "" {
+		logrus.Fatalf("Installing locked versions requires a lockfile")
 	}
 
 	v, err := versionutil.ParseVersion(version)
 	if err != nil {
 		logrus.Fatalf("Invalid version: %s", err)
 	}
+	componentVersions := compf.versions()
+	pluginConstraints := parsePlugins(plugins)
 
-	c := buildutil.NewFSBuildCache(buildCache)
+	c := cf.open()
 	if checkCache {
 		// Only do a cache check
-		if c.IsCached(v) {
+		cached := c.IsCached(v)
+		pluginVersions := resolvePlugins(pluginConstraints)
+		for _, cv := range append(componentVersions, pluginVersions...) {
+			cached = cached && c.IsCached(cv)
+		}
+		if cached {
 			fmt.Println("cached")
 			os.Exit(0)
 		}
@@ -67,10 +203,152 @@ func main() {
 		os.Exit(1)
 	}
 	if useFile != "" {
-		logrus.Fatalf("Putting file not yet supported")
+		if err := c.PutVersion(v, useFile); err != nil {
+			logrus.Fatalf("Error putting %s in cache: %s", useFile, err)
+		}
 	}
-	if err := c.InstallVersion(v, targetDir); err != nil {
-		logrus.Fatalf("Error installing %s: %s", version, err)
+	if lockfile != "" {
+		installLocked(c, lockfile, locked, version, v, componentVersions, pluginConstraints, targetDir, pluginDir)
+		return
+	}
+	pluginVersions := resolvePlugins(pluginConstraints)
+	if err := installVersions(c, targetDir, append([]versionutil.Version{v}, componentVersions...)...); err != nil {
+		logrus.Fatalf("%s", err)
+	}
+	for _, pv := range pluginVersions {
+		if err := c.InstallVersion(pv, pluginDir); err != nil {
+			logrus.Fatalf("Error installing %s: %s", pv, err)
+		}
 	}
+}
+
+// componentFlags are the flags used to select versions of
+// components to install instead of the bundled versions
+type componentFlags struct {
+	components map[string]*string
+}
+
+func (cf *componentFlags) register(fs *flag.FlagSet) {
+	cf.components = map[string]*string{}
+	for _, component := range []string{versionutil.ComponentContainerd, versionutil.ComponentRunc} {
+		cf.components[component] = fs.String(component, "", fmt.Sprintf("Version of %s to install instead of the bundled version", component))
+	}
+}
+
+func (cf *componentFlags) versions() []versionutil.Version {
+	return parseComponents(cf.components)
+}
+
+// installVersions installs each version to the target directory
+// in order, components must be installed after Docker to replace
+// the bundled binaries.
+func installVersions(c buildutil.BuildCache, targetDir string, versions ...versionutil.Version) error {
+	for _, v := range versions {
+		if err := c.InstallVersion(v, targetDir); err != nil {
+			return fmt.Errorf("error installing %s: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// parseComponents parses the versions given for each
+// component, skipping components without a version.
+func parseComponents(components map[string]*string) []versionutil.Version {
+	var versions []versionutil.Version
+	for component, cv := range components {
+		if *cv == "" {
+			continue
+		}
+		v, err := versionutil.ParseComponentVersion(component, *cv)
+		if err != nil {
+			logrus.Fatalf("Invalid %s version: %s", component, err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// parsePlugins parses the version constraints given for
+// each plugin, skipping plugins without a version.
+func parsePlugins(plugins map[string]*string) []versionutil.Constraint {
+	var constraints []versionutil.Constraint
+	for plugin, pv := range plugins {
+		if *pv == "" {
+			continue
+		}
+		c, err := versionutil.ParseComponentConstraint(plugin, *pv)
+		if err != nil {
+			logrus.Fatalf("Invalid %s version: %s", plugin, err)
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints
+}
 
+// resolvePlugins resolves the plugin version constraints to
+// the latest matching stable release.
+func resolvePlugins(constraints []versionutil.Constraint) []versionutil.Version {
+	var versions []versionutil.Version
+	for _, c := range constraints {
+		v, err := resolveConstraint(c)
+		if err != nil {
+			logrus.Fatalf("Error resolving %s: %s", c, err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// resolveConstraint returns the version selected by the constraint,
+// listing the stable releases to resolve release lines.
+func resolveConstraint(c versionutil.Constraint) (versionutil.Version, error) {
+	if v, ok := c.Exact(); ok {
+		return v, nil
+	}
+	indexURL := c.IndexURL("linux", versionutil.NativeArch(), "stable")
+	if indexURL == "" {
+		return versionutil.Version{}, fmt.Errorf("cannot list releases for %s, use an exact version", c)
+	}
+	releases, err := versionutil.ListReleases(indexURL)
+	if err != nil {
+		return versionutil.Version{}, fmt.Errorf("error listing releases: %v", err)
+	}
+	v, err := c.Resolve(releases)
+	if err != nil {
+		return versionutil.Version{}, err
+	}
+	logrus.Debugf("Resolved %s to %s", c, v)
+	return v, nil
+}
+
+// defaultPluginDir returns the directory the Docker CLI
+// loads plugins from for the current user.
+func defaultPluginDir() string {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		configDir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	return filepath.Join(configDir, "cli-plugins")
+}
+
+// openBuildCache opens the build cache for each comma separated
+// location, using a tiered cache when more than one is given.
+func openBuildCache(locations, policy string, opts ...buildutil.FSOption) (buildutil.BuildCache, error) {
+	var tiers []buildutil.BuildCache
+	for _, location := range strings.Split(locations, ",") {
+		c, err := buildutil.NewBuildCache(location, opts...)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, c)
+	}
+	if len(tiers) == 1 {
+		return tiers[0], nil
+	}
+
+	p, err := buildutil.ParseTieredPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	return buildutil.NewTieredBuildCache(p, tiers...)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+func prefetch(args []string) {
+	var cf cacheFlags
+	var concurrency int
+	var verbose bool
+	fs := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	cf.register(fs)
+	fs.IntVar(&concurrency, "j", 4, "Number of versions to download at once")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if fs.NArg() == 0 {
+		logrus.Fatalf("Must provide versions to prefetch")
+	}
+	if cf.buildCache == "" {
+		logrus.Fatalf("Must provide build cache to prefetch into")
+	}
+	var versions []versionutil.Version
+	for _, arg := range fs.Args() {
+		v, err := versionutil.ParseVersion(arg)
+		if err != nil {
+			logrus.Fatalf("Invalid version %s: %s", arg, err)
+		}
+		versions = append(versions, v)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var failed bool
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS")
+	for _, r := range buildutil.Prefetch(ctx, cf.open(), versions, concurrency) {
+		status := "fetched"
+		if r.Err != nil {
+			status = "error: " + r.Err.Error()
+			failed = true
+		} else if r.Cached {
+			status = "cached"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", r.Version, status)
+	}
+	w.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
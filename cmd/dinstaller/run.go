@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/dmcgowan/dockerdevtools/daemonutil"
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+func run(args []string) {
+	var cf cacheFlags
+	var compf componentFlags
+	var keep bool
+	var timeout time.Duration
+	var verbose bool
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	cf.register(fs)
+	compf.register(fs)
+	fs.BoolVar(&keep, "keep", false, "Keep the daemon directory after exit")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Time to wait for the daemon to start and stop")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if fs.NArg() < 1 {
+		logrus.Fatalf("Must provide version to run")
+	}
+	v, err := versionutil.ParseVersion(fs.Arg(0))
+	if err != nil {
+		logrus.Fatalf("Invalid version: %s", err)
+	}
+	componentVersions := compf.versions()
+
+	// Arguments after the version are passed to the daemon
+	err = runDaemon(cf.open(), append([]versionutil.Version{v}, componentVersions...), fs.Args()[1:], keep, timeout)
+	if err != nil {
+		logrus.Fatalf("%s", err)
+	}
+}
+
+// runDaemon installs the versions into a temporary root and runs
+// the daemon for the first version from it, until the daemon exits
+// or a signal is received. The root is removed on return unless
+// it should be kept.
+func runDaemon(c buildutil.BuildCache, versions []versionutil.Version, args []string, keep bool, timeout time.Duration) error {
+	root, err := ioutil.TempDir("", "dinstaller-run-")
+	if err != nil {
+		return fmt.Errorf("error creating temp dir: %v", err)
+	}
+	cleanup := func() error {
+		return os.RemoveAll(root)
+	}
+	defer func() {
+		if keep {
+			logrus.Infof("Daemon files kept in %s", root)
+			return
+		}
+		if err := cleanup(); err != nil {
+			logrus.Errorf("Error removing %s: %s", root, err)
+		}
+	}()
+
+	binDir := filepath.Join(root, "bin")
+	if err := installVersions(c, binDir, versions...); err != nil {
+		return err
+	}
+
+	d, err := daemonutil.StartDaemon(versions[0], binDir, root, args...)
+	if err != nil {
+		return fmt.Errorf("error starting daemon: %v", err)
+	}
+	cleanup = d.Cleanup
+	defer func() {
+		if err := d.Stop(timeout); err != nil {
+			logrus.Errorf("Error stopping daemon: %s", err)
+		}
+	}()
+
+	if err := d.WaitReady(timeout); err != nil {
+		return fmt.Errorf("error starting daemon: %v", err)
+	}
+	logrus.Infof("Running %s, logging to %s", versions[0], d.LogFile)
+	fmt.Printf("export DOCKER_HOST=%s\n", d.Host)
+	fmt.Printf("export PATH=%s:$PATH\n", binDir)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-sigs:
+		logrus.Infof("Received %s, stopping daemon", sig)
+	case <-d.Done():
+		if err := d.Err(); err != nil {
+			return fmt.Errorf("daemon exited: %v", err)
+		}
+		logrus.Infof("Daemon exited")
+	}
+	return nil
+}
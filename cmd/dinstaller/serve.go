@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/sirupsen/logrus"
+)
+
+func serve(args []string) {
+	var buildCache string
+	var listen string
+	var token string
+	var verbose bool
+	var lf limitFlags
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&buildCache, "bc", "", "Directory of the build cache to serve")
+	fs.StringVar(&listen, "listen", ":8080", "Address to listen on")
+	fs.StringVar(&token, "token", os.Getenv("DEVTOOLS_CACHE_TOKEN"), "Token required for uploads, uploads disabled if empty")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	lf.register(fs)
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if buildCache == "" {
+		logrus.Fatalf("Must provide build cache directory")
+	}
+	if fi, err := os.Stat(buildCache); err != nil {
+		logrus.Fatalf("Error calling stat on build cache: %s", err)
+	} else if !fi.IsDir() {
+		logrus.Fatalf("Build cache is not a directory: %s", buildCache)
+	}
+	if token == "" {
+		logrus.Warnf("No token provided, uploads disabled")
+	}
+
+	logrus.Infof("Serving %s on %s", buildCache, listen)
+	if err := http.ListenAndServe(listen, buildutil.NewCacheServer(buildCache, token, lf.options()...)); err != nil {
+		logrus.Fatalf("Error serving: %s", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/daemonutil"
+	"github.com/sirupsen/logrus"
+)
+
+func setupDaemon(args []string) {
+	var s daemonutil.Setup
+	var verbose bool
+	fs := flag.NewFlagSet("setup-daemon", flag.ExitOnError)
+	fs.StringVar(&s.BinDir, "t", filepath.Join(os.Getenv("HOME"), ".bin"), "Directory of the installed binaries")
+	fs.StringVar(&s.Dir, "o", filepath.Join(os.Getenv("HOME"), ".config", "dockerdevtools", "daemon"), "Directory to write the daemon setup")
+	fs.StringVar(&s.DataRoot, "data-root", "", "Root directory of the daemon state")
+	fs.StringVar(&s.StorageDriver, "storage-driver", "", "Storage driver to use")
+	fs.BoolVar(&s.Rootless, "rootless", false, "Run the daemon as the current user with a systemd user unit")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	written, err := s.Write()
+	if err != nil {
+		logrus.Fatalf("Error writing daemon setup: %s", err)
+	}
+	for _, f := range written {
+		fmt.Println(f)
+	}
+
+	systemctl := "systemctl"
+	if s.Rootless {
+		systemctl = "systemctl --user"
+	}
+	unit, err := filepath.Abs(filepath.Join(s.Dir, daemonutil.UnitFile))
+	if err != nil {
+		logrus.Fatalf("Error resolving unit path: %s", err)
+	}
+	logrus.Infof("Enable with: %s link %s && %s daemon-reload && %s start docker", systemctl, unit, systemctl, systemctl)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcgowan/dockerdevtools/buildutil"
+	"github.com/sirupsen/logrus"
+)
+
+func syncVersions(args []string) {
+	var cf cacheFlags
+	var manifest string
+	var concurrency int
+	var locked bool
+	var verbose bool
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	cf.register(fs)
+	fs.StringVar(&manifest, "f", "versions.yaml", "Manifest listing the versions to install")
+	fs.IntVar(&concurrency, "j", 4, "Number of versions to install at once")
+	fs.BoolVar(&locked, "locked", false, "Only install the versions and digests recorded in the lockfile")
+	fs.BoolVar(&verbose, "v", false, "Verbose logging")
+	fs.Parse(args)
+	if verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	m, err := buildutil.ReadManifest(manifest)
+	if err != nil {
+		logrus.Fatalf("Error reading manifest: %s", err)
+	}
+
+	lockfile := buildutil.LockfilePath(manifest)
+	previous, err := buildutil.ReadLockfile(lockfile)
+	if err != nil && (locked || !os.IsNotExist(err)) {
+		logrus.Fatalf("Error reading lockfile: %s", err)
+	}
+
+	l, err := buildutil.Sync(cf.open(), m, filepath.Dir(manifest), buildutil.SyncOptions{
+		Concurrency: concurrency,
+		Previous:    previous,
+		Locked:      locked,
+	})
+	if err != nil {
+		logrus.Fatalf("Error syncing versions: %s", err)
+	}
+	if err := buildutil.WriteLockfile(lockfile, l); err != nil {
+		logrus.Fatalf("Error writing lockfile: %s", err)
+	}
+
+	for _, lv := range l.Versions {
+		fmt.Printf("%s\t%s\t%s\n", lv.Version, lv.Arch, lv.Dir)
+	}
+}
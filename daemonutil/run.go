@@ -0,0 +1,171 @@
+package daemonutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Daemon is a daemon process isolated from the system daemon,
+// with all of its state stored under a root directory.
+type Daemon struct {
+	// Root is the directory holding the daemon state
+	Root string
+
+	// Host is the address of the daemon socket, used
+	// as DOCKER_HOST by clients
+	Host string
+
+	// LogFile is the file the daemon output is written to
+	LogFile string
+
+	socket string
+	cmd    *exec.Cmd
+	done   chan struct{}
+	err    error
+}
+
+// StartDaemon starts the daemon for the version from the binaries
+// installed in binDir, with its data root, exec root, pidfile and
+// socket under root. The binary directory is added to the PATH so
+// the binaries installed alongside the daemon are used. Additional
+// arguments are passed to the daemon.
+func StartDaemon(v versionutil.Version, binDir, root string, args ...string) (*Daemon, error) {
+	binDir, err := filepath.Abs(binDir)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"data", "exec"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &Daemon{
+		Root:    root,
+		LogFile: filepath.Join(root, logFile),
+		socket:  filepath.Join(root, "docker.sock"),
+		done:    make(chan struct{}),
+	}
+	d.Host = "unix://" + d.socket
+
+	logs, err := os.Create(d.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+
+	binary, daemonArgs := v.DaemonCommand()
+	daemonArgs = append(daemonArgs,
+		v.DataRootFlag(), filepath.Join(root, "data"),
+		"--exec-root", filepath.Join(root, "exec"),
+		"--pidfile", filepath.Join(root, pidFile),
+		"--host", d.Host,
+	)
+	d.cmd = exec.Command(filepath.Join(binDir, binary), append(daemonArgs, args...)...)
+	d.cmd.Stdout = logs
+	d.cmd.Stderr = logs
+	d.cmd.Env = append(filterEnv(os.Environ(), "PATH", "DOCKER_HOST"), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	logrus.Debugf("Starting %s", strings.Join(d.cmd.Args, " "))
+	if err := d.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		d.err = d.cmd.Wait()
+		close(d.done)
+	}()
+
+	return d, nil
+}
+
+func filterEnv(env []string, names ...string) []string {
+	filtered := env[:0:0]
+	for _, e := range env {
+		var skip bool
+		for _, name := range names {
+			if strings.HasPrefix(e, name+"=") {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// Done is closed when the daemon exits
+func (d *Daemon) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns the exit error after the daemon has exited
+func (d *Daemon) Err() error {
+	<-d.done
+	return d.err
+}
+
+// WaitReady waits for the daemon to create its socket, returning
+// an error if the daemon exits or the timeout is reached first.
+func (d *Daemon) WaitReady(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		if _, err := os.Stat(d.socket); err == nil {
+			return nil
+		}
+		select {
+		case <-d.done:
+			if d.err != nil {
+				return fmt.Errorf("daemon exited: %v, see %s", d.err, d.LogFile)
+			}
+			return fmt.Errorf("daemon exited, see %s", d.LogFile)
+		case <-deadline:
+			return errors.New("timed out waiting for daemon to start")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Stop terminates the daemon, killing it if it has not exited
+// within the timeout.
+func (d *Daemon) Stop(timeout time.Duration) error {
+	select {
+	case <-d.done:
+		return nil
+	default:
+	}
+	if err := d.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logrus.Debugf("Error signaling daemon: %v", err)
+	}
+	select {
+	case <-d.done:
+		return nil
+	case <-time.After(timeout):
+	}
+	logrus.Warnf("Daemon did not exit after %s, killing", timeout)
+	if err := d.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-d.done
+	return nil
+}
+
+// Cleanup removes the daemon root directory, the daemon
+// must be stopped first.
+func (d *Daemon) Cleanup() error {
+	return os.RemoveAll(d.Root)
+}
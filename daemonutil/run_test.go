@@ -0,0 +1,130 @@
+package daemonutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+// fakeDockerd records its arguments and creates the socket given
+// by --host, running until terminated.
+const fakeDockerd = `#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+echo "$PATH" > "$(dirname "$0")/path"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--host) socket="${2#unix://}"; shift ;;
+	esac
+	shift
+done
+trap 'rm -f "$socket"; exit 0' TERM
+touch "$socket"
+while true; do sleep 0.1; done
+`
+
+func writeFakeDaemon(t *testing.T, dir, name, script string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func parseVersion(t *testing.T, s string) versionutil.Version {
+	v, err := versionutil.ParseVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestStartDaemon(t *testing.T) {
+	td, cleanup := setupDirs(t)
+	defer cleanup()
+
+	binDir := filepath.Join(td, "bin")
+	writeFakeDaemon(t, binDir, "dockerd", fakeDockerd)
+	root := filepath.Join(td, "daemon")
+
+	d, err := StartDaemon(parseVersion(t, "18.09.0"), binDir, root, "--debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WaitReady(5 * time.Second); err != nil {
+		d.Stop(time.Second)
+		t.Fatal(err)
+	}
+	if expected := "unix://" + filepath.Join(root, "docker.sock"); d.Host != expected {
+		t.Errorf("Unexpected host %s, expected %s", d.Host, expected)
+	}
+
+	args := readFile(t, filepath.Join(binDir, "args"))
+	checkContains(t, "args", args,
+		"--data-root "+filepath.Join(root, "data"),
+		"--exec-root "+filepath.Join(root, "exec"),
+		"--pidfile "+filepath.Join(root, pidFile),
+		"--host "+d.Host,
+		"--debug",
+	)
+	if path := readFile(t, filepath.Join(binDir, "path")); !strings.HasPrefix(path, binDir+":") {
+		t.Errorf("Expected PATH to start with %s: %s", binDir, path)
+	}
+
+	if err := d.Stop(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d.Done():
+	default:
+		t.Fatal("Daemon should have exited")
+	}
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Fatalf("Expected daemon root to be removed: %v", err)
+	}
+}
+
+func TestStartDaemonExit(t *testing.T) {
+	td, cleanup := setupDirs(t)
+	defer cleanup()
+
+	binDir := filepath.Join(td, "bin")
+	writeFakeDaemon(t, binDir, "dockerd", "#!/bin/sh\necho failed to start >&2\nexit 1\n")
+	d, err := StartDaemon(parseVersion(t, "18.09.0"), binDir, filepath.Join(td, "daemon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WaitReady(5 * time.Second); err == nil {
+		t.Fatal("Expected error from exited daemon")
+	}
+	checkContains(t, "log", readFile(t, d.LogFile), "failed to start")
+}
+
+func TestStartLegacyDaemon(t *testing.T) {
+	td, cleanup := setupDirs(t)
+	defer cleanup()
+
+	binDir := filepath.Join(td, "bin")
+	writeFakeDaemon(t, binDir, "docker", fakeDockerd)
+	root := filepath.Join(td, "daemon")
+
+	d, err := StartDaemon(parseVersion(t, "1.11.2"), binDir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop(5 * time.Second)
+	if err := d.WaitReady(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	args := readFile(t, filepath.Join(binDir, "args"))
+	if !strings.HasPrefix(args, "daemon ") {
+		t.Errorf("Expected daemon subcommand: %s", args)
+	}
+	checkContains(t, "args", args, "--graph "+filepath.Join(root, "data"))
+}
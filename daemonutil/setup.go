@@ -0,0 +1,240 @@
+// Package daemonutil provides utility functions for
+// configuring and running installed Docker daemons.
+package daemonutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/dmcgowan/dockerdevtools/versionutil"
+)
+
+// Setup configures the files generated to run an installed
+// Docker daemon.
+type Setup struct {
+	// BinDir is the directory holding the installed binaries
+	BinDir string
+
+	// Dir is the directory the files are written to
+	Dir string
+
+	// DataRoot is the root directory of the daemon state,
+	// the daemon default is used when empty.
+	DataRoot string
+
+	// Version is the version of the installed daemon, used to
+	// name the configuration. Detected from the installed
+	// daemon when empty.
+	Version versionutil.Version
+
+	// StorageDriver is the storage driver used by the daemon,
+	// the daemon default is used when empty.
+	StorageDriver string
+
+	// Rootless runs the daemon as the current user using
+	// dockerd-rootless.sh with a systemd user unit.
+	Rootless bool
+}
+
+// DaemonConfig is the daemon configuration written to
+// daemon.json
+type DaemonConfig struct {
+	// Graph is the data root for daemons before 17.05
+	Graph         string `json:"graph,omitempty"`
+	DataRoot      string `json:"data-root,omitempty"`
+	StorageDriver string `json:"storage-driver,omitempty"`
+	Pidfile       string `json:"pidfile,omitempty"`
+}
+
+// DefaultHost returns the address of the socket of a daemon run with
+// the generated setup, the socket of the rootless daemon for the
+// current user when it is running and the system socket otherwise.
+func DefaultHost() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		socket := filepath.Join(dir, "docker.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return "unix:///var/run/docker.sock"
+}
+
+// Names of the generated files
+const (
+	DaemonConfigFile = "daemon.json"
+	UnitFile         = "docker.service"
+	StartScript      = "start.sh"
+	StopScript       = "stop.sh"
+	pidFile          = "docker.pid"
+	logFile          = "dockerd.log"
+)
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Docker Application Container Engine ({{.BinDir}})
+Documentation=https://docs.docker.com
+{{- if not .Rootless}}
+After=network-online.target
+Wants=network-online.target
+{{- end}}
+
+[Service]
+Type=notify
+{{- if .Rootless}}
+NotifyAccess=all
+{{- end}}
+Environment=PATH={{.BinDir}}:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin
+ExecStart={{.Daemon}} --config-file {{.ConfigFile}}
+ExecReload=/bin/kill -s HUP $MAINPID
+TimeoutSec=0
+RestartSec=2
+Restart=always
+StartLimitBurst=3
+StartLimitInterval=60s
+LimitNOFILE=infinity
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+Delegate=yes
+{{- if .Rootless}}
+KillMode=mixed
+{{- else}}
+KillMode=process
+{{- end}}
+
+[Install]
+{{- if .Rootless}}
+WantedBy=default.target
+{{- else}}
+WantedBy=multi-user.target
+{{- end}}
+`))
+
+var startTemplate = template.Must(template.New("start").Parse(`#!/bin/sh
+# Starts the daemon without systemd
+set -e
+if [ -f "{{.PidFile}}" ] && kill -0 "$(cat "{{.PidFile}}")" 2>/dev/null; then
+	echo "dockerd already running" >&2
+	exit 1
+fi
+PATH="{{.BinDir}}:$PATH" nohup "{{.Daemon}}" --config-file "{{.ConfigFile}}" >> "{{.LogFile}}" 2>&1 &
+echo "dockerd started, logging to {{.LogFile}}"
+`))
+
+var stopTemplate = template.Must(template.New("stop").Parse(`#!/bin/sh
+# Stops the daemon started by start.sh
+set -e
+if [ ! -f "{{.PidFile}}" ]; then
+	echo "dockerd not running" >&2
+	exit 1
+fi
+kill "$(cat "{{.PidFile}}")"
+`))
+
+type templateArgs struct {
+	BinDir     string
+	Daemon     string
+	ConfigFile string
+	PidFile    string
+	LogFile    string
+	Rootless   bool
+}
+
+// Write writes the daemon configuration, systemd unit and start
+// and stop scripts to the setup directory, returning the paths
+// of the written files.
+func (s Setup) Write() ([]string, error) {
+	binDir, err := filepath.Abs(s.BinDir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	daemon := filepath.Join(binDir, "dockerd")
+	if s.Rootless {
+		daemon = filepath.Join(binDir, "dockerd-rootless.sh")
+	}
+	if _, err := os.Stat(daemon); err != nil {
+		return nil, fmt.Errorf("daemon not installed: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	args := templateArgs{
+		BinDir:     binDir,
+		Daemon:     daemon,
+		ConfigFile: filepath.Join(dir, DaemonConfigFile),
+		PidFile:    filepath.Join(dir, pidFile),
+		LogFile:    filepath.Join(dir, logFile),
+		Rootless:   s.Rootless,
+	}
+
+	config := DaemonConfig{
+		StorageDriver: s.StorageDriver,
+		Pidfile:       args.PidFile,
+	}
+	if s.DataRoot != "" {
+		v := s.Version
+		if v.Name == "" {
+			v, err = versionutil.BinaryVersion(filepath.Join(binDir, "dockerd"))
+			if err != nil {
+				return nil, fmt.Errorf("error getting daemon version: %v", err)
+			}
+		}
+		if v.DataRootFlag() == "--graph" {
+			config.Graph = s.DataRoot
+		} else {
+			config.DataRoot = s.DataRoot
+		}
+	}
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(args.ConfigFile, append(b, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	written := []string{args.ConfigFile}
+
+	for _, f := range []struct {
+		name string
+		t    *template.Template
+		mode os.FileMode
+	}{
+		{UnitFile, unitTemplate, 0644},
+		{StartScript, startTemplate, 0755},
+		{StopScript, stopTemplate, 0755},
+	} {
+		p := filepath.Join(dir, f.name)
+		if err := writeTemplate(p, f.t, args, f.mode); err != nil {
+			return nil, err
+		}
+		written = append(written, p)
+	}
+
+	return written, nil
+}
+
+func writeTemplate(p string, t *template.Template, args templateArgs, mode os.FileMode) error {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(f, args); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Ensure mode when overwriting an existing file
+	return os.Chmod(p, mode)
+}
@@ -0,0 +1,162 @@
+package daemonutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupDirs(t *testing.T, binaries ...string) (string, func()) {
+	td, err := ioutil.TempDir("", "daemonutil-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binDir := filepath.Join(td, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range binaries {
+		if err := ioutil.WriteFile(filepath.Join(binDir, b), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return td, func() { os.RemoveAll(td) }
+}
+
+func readFile(t *testing.T, p string) string {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func checkContains(t *testing.T, name, content string, expected ...string) {
+	for _, e := range expected {
+		if !strings.Contains(content, e) {
+			t.Errorf("Expected %s to contain %q:\n%s", name, e, content)
+		}
+	}
+}
+
+func TestWriteSetup(t *testing.T) {
+	td, cleanup := setupDirs(t)
+	defer cleanup()
+
+	binDir := filepath.Join(td, "bin")
+	writeFakeDaemon(t, binDir, "dockerd", "#!/bin/sh\necho 'Docker version 18.09.0, build 4d60db4'\n")
+	dir := filepath.Join(td, "setup")
+	written, err := Setup{
+		BinDir:        binDir,
+		Dir:           dir,
+		DataRoot:      "/var/lib/docker-dev",
+		StorageDriver: "overlay2",
+	}.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 4 {
+		t.Fatalf("Unexpected written files: %v", written)
+	}
+
+	var config DaemonConfig
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(dir, DaemonConfigFile))), &config); err != nil {
+		t.Fatal(err)
+	}
+	expected := DaemonConfig{
+		DataRoot:      "/var/lib/docker-dev",
+		StorageDriver: "overlay2",
+		Pidfile:       filepath.Join(dir, pidFile),
+	}
+	if config != expected {
+		t.Fatalf("Unexpected config %#v, expected %#v", config, expected)
+	}
+
+	checkContains(t, UnitFile, readFile(t, filepath.Join(dir, UnitFile)),
+		"ExecStart="+filepath.Join(binDir, "dockerd")+" --config-file "+filepath.Join(dir, DaemonConfigFile),
+		"Environment=PATH="+binDir+":",
+		"KillMode=process",
+		"WantedBy=multi-user.target",
+	)
+	checkContains(t, StartScript, readFile(t, filepath.Join(dir, StartScript)),
+		`"`+filepath.Join(binDir, "dockerd")+`" --config-file`,
+	)
+	checkContains(t, StopScript, readFile(t, filepath.Join(dir, StopScript)),
+		filepath.Join(dir, pidFile),
+	)
+	for _, script := range []string{StartScript, StopScript} {
+		if fi, err := os.Stat(filepath.Join(dir, script)); err != nil {
+			t.Fatal(err)
+		} else if fi.Mode()&0111 == 0 {
+			t.Errorf("Expected %s to be executable", script)
+		}
+	}
+}
+
+func TestWriteLegacySetup(t *testing.T) {
+	td, cleanup := setupDirs(t, "dockerd")
+	defer cleanup()
+
+	dir := filepath.Join(td, "setup")
+	if _, err := (Setup{
+		BinDir:   filepath.Join(td, "bin"),
+		Dir:      dir,
+		DataRoot: "/var/lib/docker-dev",
+		Version:  parseVersion(t, "17.03.0-ce"),
+	}).Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	var config DaemonConfig
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(dir, DaemonConfigFile))), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Graph != "/var/lib/docker-dev" || config.DataRoot != "" {
+		t.Fatalf("Expected graph in config before 17.05: %#v", config)
+	}
+}
+
+func TestWriteRootlessSetup(t *testing.T) {
+	td, cleanup := setupDirs(t, "dockerd", "dockerd-rootless.sh")
+	defer cleanup()
+
+	binDir := filepath.Join(td, "bin")
+	dir := filepath.Join(td, "setup")
+	if _, err := (Setup{
+		BinDir:   binDir,
+		Dir:      dir,
+		Rootless: true,
+	}).Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	unit := readFile(t, filepath.Join(dir, UnitFile))
+	checkContains(t, UnitFile, unit,
+		"ExecStart="+filepath.Join(binDir, "dockerd-rootless.sh"),
+		"NotifyAccess=all",
+		"KillMode=mixed",
+		"WantedBy=default.target",
+	)
+	if strings.Contains(unit, "network-online.target") {
+		t.Errorf("User unit should not depend on system targets:\n%s", unit)
+	}
+	if config := readFile(t, filepath.Join(dir, DaemonConfigFile)); strings.Contains(config, "data-root") {
+		t.Errorf("Unexpected data root in config:\n%s", config)
+	}
+}
+
+func TestWriteSetupMissingDaemon(t *testing.T) {
+	td, cleanup := setupDirs(t, "dockerd")
+	defer cleanup()
+
+	if _, err := (Setup{
+		BinDir:   filepath.Join(td, "bin"),
+		Dir:      filepath.Join(td, "setup"),
+		Rootless: true,
+	}).Write(); err == nil {
+		t.Fatal("Expected error without dockerd-rootless.sh")
+	}
+}
@@ -0,0 +1,69 @@
+package versionutil
+
+// apiVersions are the API versions of each Docker release,
+// ordered by the first version number using the API.
+var apiVersions = []struct {
+	since [3]int
+	api   string
+}{
+	{[3]int{1, 6, 0}, "1.18"},
+	{[3]int{1, 7, 0}, "1.19"},
+	{[3]int{1, 8, 0}, "1.20"},
+	{[3]int{1, 9, 0}, "1.21"},
+	{[3]int{1, 10, 0}, "1.22"},
+	{[3]int{1, 11, 0}, "1.23"},
+	{[3]int{1, 12, 0}, "1.24"},
+	{[3]int{1, 13, 0}, "1.25"},
+	{[3]int{1, 13, 1}, "1.26"},
+	{[3]int{17, 4, 0}, "1.27"},
+	{[3]int{17, 5, 0}, "1.29"},
+	{[3]int{17, 6, 0}, "1.30"},
+	{[3]int{17, 7, 0}, "1.31"},
+	{[3]int{17, 9, 0}, "1.32"},
+	{[3]int{17, 10, 0}, "1.33"},
+	{[3]int{17, 11, 0}, "1.34"},
+	{[3]int{17, 12, 0}, "1.35"},
+	{[3]int{18, 2, 0}, "1.36"},
+	{[3]int{18, 3, 0}, "1.37"},
+	{[3]int{18, 6, 0}, "1.38"},
+	{[3]int{18, 9, 0}, "1.39"},
+	{[3]int{19, 3, 0}, "1.40"},
+	{[3]int{20, 10, 0}, "1.41"},
+	{[3]int{23, 0, 0}, "1.42"},
+	{[3]int{24, 0, 0}, "1.43"},
+	{[3]int{25, 0, 0}, "1.44"},
+	{[3]int{26, 0, 0}, "1.45"},
+	{[3]int{27, 0, 0}, "1.46"},
+	{[3]int{27, 2, 0}, "1.47"},
+	{[3]int{28, 0, 0}, "1.48"},
+}
+
+// APIVersion returns the API version supported by the Docker
+// version. Pre-releases and builds use the API version of the
+// release with the same version number. An empty string is
+// returned for components and versions before 1.6.
+func (v Version) APIVersion() string {
+	if v.Component != "" {
+		return ""
+	}
+	var api string
+	for _, a := range apiVersions {
+		if compareNumbers(v.versionNumber, a.since) < 0 {
+			break
+		}
+		api = a.api
+	}
+	return api
+}
+
+func compareNumbers(n1, n2 [3]int) int {
+	for i := range n1 {
+		if n1[i] != n2[i] {
+			if n1[i] < n2[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
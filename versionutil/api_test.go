@@ -0,0 +1,30 @@
+package versionutil
+
+import "testing"
+
+func TestAPIVersion(t *testing.T) {
+	for version, expected := range map[string]string{
+		"1.5.0":                  "",
+		"1.6.2":                  "1.18",
+		"1.9.0-dev@aaaaaa":       "1.21",
+		"1.13.0":                 "1.25",
+		"1.13.1":                 "1.26",
+		"17.03.0-ce":             "1.26",
+		"17.06.0-ce":             "1.30",
+		"17.06.0-dev@abcdef1234": "1.30",
+		"17.08.0-ce":             "1.31",
+		"18.09.0":                "1.39",
+		"20.10.7":                "1.41",
+		"27.3.1":                 "1.47",
+		"30.0.0":                 "1.48",
+		"containerd-1.2.5":       "",
+	} {
+		v, err := ParseVersion(version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if api := v.APIVersion(); api != expected {
+			t.Errorf("Unexpected API version for %s: %q, expected %q", version, api, expected)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package versionutil
+
+import "fmt"
+
+// Components distributed separately from Docker
+const (
+	ComponentContainerd = "containerd"
+	ComponentRunc       = "runc"
+	ComponentBuildx     = "buildx"
+	ComponentCompose    = "compose"
+)
+
+// Plugins are the components which are Docker CLI plugins
+var Plugins = []string{ComponentBuildx, ComponentCompose}
+
+// componentReleases are the release eras for each component,
+// ordered by version
+var componentReleases = map[string][]Release{
+	ComponentContainerd: {
+		{
+			// Releases before 2.0 include the v1 shim
+			Name:        "containerd-shim",
+			Before:      tagVersion(2, 0, 0, "beta"),
+			URLTemplate: "https://github.com/containerd/containerd/releases/download/v{version}{tag}/containerd-{version}{tag}.{os}-{goarch}.tar.gz",
+			Archive:     ArchiveTarball,
+			BinaryDir:   "bin",
+			Binaries:    []string{"containerd", "containerd-shim", "ctr"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "beta"},
+				{TagPrefix: "rc"},
+			},
+			BundledNames: map[string]string{
+				"containerd":      "docker-containerd",
+				"containerd-shim": "docker-containerd-shim",
+				"ctr":             "docker-containerd-ctr",
+			},
+		},
+		{
+			Name:        "containerd",
+			URLTemplate: "https://github.com/containerd/containerd/releases/download/v{version}{tag}/containerd-{version}{tag}.{os}-{goarch}.tar.gz",
+			Archive:     ArchiveTarball,
+			BinaryDir:   "bin",
+			Binaries:    []string{"containerd", "ctr"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "beta"},
+				{TagPrefix: "rc"},
+			},
+			BundledNames: map[string]string{
+				"containerd": "docker-containerd",
+				"ctr":        "docker-containerd-ctr",
+			},
+		},
+	},
+	ComponentRunc: {
+		{
+			Name:        "runc",
+			URLTemplate: "https://github.com/opencontainers/runc/releases/download/v{version}{tag}/runc.{goarch}",
+			Archive:     ArchiveBinary,
+			Binaries:    []string{"runc"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "rc"},
+			},
+			BundledNames: map[string]string{
+				"runc": "docker-runc",
+			},
+		},
+	},
+	ComponentBuildx: {
+		{
+			Name:          "buildx",
+			IndexTemplate: "https://api.github.com/repos/docker/buildx/releases?per_page=100",
+			URLTemplate:   "https://github.com/docker/buildx/releases/download/v{version}{tag}/buildx-v{version}{tag}.{os}-{goarch}",
+			Archive:       ArchiveBinary,
+			Binaries:      []string{"docker-buildx"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "rc"},
+			},
+		},
+	},
+	ComponentCompose: {
+		{
+			// Releases before 2.0.0 are not CLI plugins
+			Name:        "compose-standalone",
+			Before:      tagVersion(2, 0, 0, "alpha"),
+			Archive:     ArchiveBinary,
+			Binaries:    []string{"docker-compose"},
+			Unsupported: "compose releases before 2.0 are not CLI plugins",
+		},
+		{
+			// Releases before 2.1.1 use Go architecture names
+			Name:          "compose-goarch",
+			Before:        tagVersion(2, 1, 1, ""),
+			IndexTemplate: "https://api.github.com/repos/docker/compose/releases?per_page=100",
+			URLTemplate:   "https://github.com/docker/compose/releases/download/v{version}{tag}/docker-compose-{os}-{goarch}",
+			Archive:       ArchiveBinary,
+			Binaries:      []string{"docker-compose"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "rc"},
+			},
+		},
+		{
+			Name:          "compose",
+			IndexTemplate: "https://api.github.com/repos/docker/compose/releases?per_page=100",
+			URLTemplate:   "https://github.com/docker/compose/releases/download/v{version}{tag}/docker-compose-{os}-{arch}",
+			Archive:       ArchiveBinary,
+			Binaries:      []string{"docker-compose"},
+			Channels: []Channel{
+				{},
+				{TagPrefix: "rc"},
+			},
+		},
+	},
+}
+
+// ParseComponentVersion parses the version of a component
+// distributed separately from Docker, such as "1.2.5" for
+// containerd.
+func ParseComponentVersion(component, s string) (Version, error) {
+	if _, ok := componentReleases[component]; !ok {
+		return Version{}, fmt.Errorf("unknown component %q", component)
+	}
+	v, err := parseVersion(s)
+	if err != nil {
+		return Version{}, err
+	}
+	v.Component = component
+	return v, nil
+}
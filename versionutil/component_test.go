@@ -0,0 +1,87 @@
+package versionutil
+
+import "testing"
+
+func TestComponentVersions(t *testing.T) {
+	cases := []struct {
+		Test      string
+		Component string
+		String    string
+		URL       string
+	}{
+		{
+			Test:      "containerd-1.2.5",
+			Component: ComponentContainerd,
+			String:    "containerd-1.2.5",
+			URL:       "https://github.com/containerd/containerd/releases/download/v1.2.5/containerd-1.2.5.linux-amd64.tar.gz",
+		},
+		{
+			Test:      "containerd-1.3.0-rc2",
+			Component: ComponentContainerd,
+			String:    "containerd-1.3.0-rc2",
+			URL:       "https://github.com/containerd/containerd/releases/download/v1.3.0-rc2/containerd-1.3.0-rc2.linux-amd64.tar.gz",
+		},
+		{
+			Test:      "containerd-2.0.0",
+			Component: ComponentContainerd,
+			String:    "containerd-2.0.0",
+			URL:       "https://github.com/containerd/containerd/releases/download/v2.0.0/containerd-2.0.0.linux-amd64.tar.gz",
+		},
+		{
+			Test:      "runc-v1.0.0-rc6",
+			Component: ComponentRunc,
+			String:    "runc-v1.0.0-rc6",
+			URL:       "https://github.com/opencontainers/runc/releases/download/v1.0.0-rc6/runc.amd64",
+		},
+		{
+			Test:      "buildx-0.5.1",
+			Component: ComponentBuildx,
+			String:    "buildx-0.5.1",
+			URL:       "https://github.com/docker/buildx/releases/download/v0.5.1/buildx-v0.5.1.linux-amd64",
+		},
+		{
+			Test:      "compose-2.0.1",
+			Component: ComponentCompose,
+			String:    "compose-2.0.1",
+			URL:       "https://github.com/docker/compose/releases/download/v2.0.1/docker-compose-linux-amd64",
+		},
+		{
+			Test:      "compose-2.2.3",
+			Component: ComponentCompose,
+			String:    "compose-2.2.3",
+			URL:       "https://github.com/docker/compose/releases/download/v2.2.3/docker-compose-linux-x86_64",
+		},
+		{
+			Test:      "compose-1.29.2",
+			Component: ComponentCompose,
+			String:    "compose-1.29.2",
+		},
+		{
+			Test:   "1.2.5",
+			String: "1.2.5",
+			URL:    "https://get.docker.com/builds/Linux/x86_64/docker-1.2.5",
+		},
+	}
+	for _, tc := range cases {
+		v, err := ParseVersion(tc.Test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Component != tc.Component {
+			t.Errorf("Unexpected component for %s: %q, expected %q", tc.Test, v.Component, tc.Component)
+		}
+		if s := v.String(); s != tc.String {
+			t.Errorf("Unexpected string for %s: %s, expected %s", tc.Test, s, tc.String)
+		}
+		if url := v.downloadURL("linux", "x86_64"); url != tc.URL {
+			t.Errorf("Unexpected URL for %s: %q, expected %q", tc.Test, url, tc.URL)
+		}
+		if v2, err := ParseVersion(v.String()); err != nil || v2 != v {
+			t.Errorf("Version %s does not round trip: %#v", v, v2)
+		}
+	}
+
+	if _, err := ParseComponentVersion("unknown", "1.0.0"); err == nil {
+		t.Fatal("Expected error parsing unknown component")
+	}
+}
@@ -0,0 +1,211 @@
+package versionutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Constraint selects a version, either an exact version such as
+// "18.09.1" or a release line such as "18.09" or "18.09.x" which
+// selects the latest release in the line.
+type Constraint struct {
+	s         string
+	component string
+	exact     *Version
+	line      [2]int
+}
+
+var lineRegexp = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)(?:\.x)?$`)
+
+// ParseConstraint parses a version constraint
+func ParseConstraint(s string) (Constraint, error) {
+	if m := lineRegexp.FindStringSubmatch(s); m != nil {
+		c := Constraint{s: s}
+		c.line[0], _ = strconv.Atoi(m[1])
+		c.line[1], _ = strconv.Atoi(m[2])
+		return c, nil
+	}
+	v, err := ParseVersion(s)
+	if err != nil {
+		return Constraint{}, fmt.Errorf("invalid constraint %q: %v", s, err)
+	}
+	return Constraint{s: s, exact: &v}, nil
+}
+
+// ParseComponentConstraint parses a version constraint for a
+// component distributed separately from Docker. Constraints
+// selecting versions which cannot be installed are rejected.
+// The constraint is named with the component prefix, matching
+// the component versions.
+func ParseComponentConstraint(component, s string) (Constraint, error) {
+	c := Constraint{s: component + "-" + s, component: component}
+	if m := lineRegexp.FindStringSubmatch(s); m != nil {
+		if _, ok := componentReleases[component]; !ok {
+			return Constraint{}, fmt.Errorf("unknown component %q", component)
+		}
+		c.line[0], _ = strconv.Atoi(m[1])
+		c.line[1], _ = strconv.Atoi(m[2])
+	} else {
+		v, err := ParseComponentVersion(component, s)
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid constraint %q: %v", s, err)
+		}
+		c.exact = &v
+	}
+	if r := c.release(); r.Unsupported != "" {
+		return Constraint{}, fmt.Errorf("cannot install %s %s: %s", component, s, r.Unsupported)
+	}
+	return c, nil
+}
+
+func (c Constraint) String() string {
+	return c.s
+}
+
+// Exact returns the version when the constraint is an exact version
+func (c Constraint) Exact() (Version, bool) {
+	if c.exact == nil {
+		return Version{}, false
+	}
+	return *c.exact, true
+}
+
+// Match returns whether the version satisfies the constraint
+func (c Constraint) Match(v Version) bool {
+	if c.exact != nil {
+		return c.exact.versionNumber == v.versionNumber && c.exact.Tag == v.Tag && c.exact.Commit == v.Commit
+	}
+	return v.versionNumber[0] == c.line[0] && v.versionNumber[1] == c.line[1]
+}
+
+// Resolve returns the latest of the versions which satisfies
+// the constraint.
+func (c Constraint) Resolve(versions []Version) (Version, error) {
+	if v, ok := c.Exact(); ok {
+		return v, nil
+	}
+	var latest *Version
+	for i := range versions {
+		if c.Match(versions[i]) && (latest == nil || latest.LessThan(versions[i])) {
+			latest = &versions[i]
+		}
+	}
+	if latest == nil {
+		return Version{}, fmt.Errorf("no release matches %s", c)
+	}
+	v := *latest
+	v.Component = c.component
+	return v, nil
+}
+
+// IndexURL returns the location listing the releases in the
+// channel for the constraint, empty if the releases cannot be
+// listed.
+func (c Constraint) IndexURL(os, arch, channel string) string {
+	return c.release().indexURL(os, arch, channel)
+}
+
+// release returns the release era of the constraint, using
+// the first version in the line for release lines.
+func (c Constraint) release() Release {
+	if c.exact != nil {
+		return c.exact.Release()
+	}
+	v := StaticVersion(c.line[0], c.line[1], 0)
+	v.Component = c.component
+	return v.Release()
+}
+
+var releaseLink = regexp.MustCompile(`href="docker-([0-9][^"/]*)\.tgz"`)
+
+// githubRelease is a release returned by the GitHub releases API
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// nextLink matches the next page in a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// ListReleases returns the versions linked from a release index.
+// Indexes from the GitHub releases API are also supported, draft
+// and pre-releases are not listed from these. Every page of the
+// GitHub releases is listed.
+func ListReleases(indexURL string) ([]Version, error) {
+	var versions []Version
+	for indexURL != "" {
+		page, next, err := listReleasePage(indexURL)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, page...)
+		indexURL = next
+	}
+	return versions, nil
+}
+
+// listReleasePage returns the versions from a page of the release
+// index and the location of the next page, empty on the last page.
+func listReleasePage(indexURL string) ([]Version, string, error) {
+	resp, err := http.Get(indexURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status listing %s: %s", indexURL, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var versions []Version
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var rs []githubRelease
+		if err := json.Unmarshal(b, &rs); err != nil {
+			return nil, "", fmt.Errorf("invalid release list from %s: %v", indexURL, err)
+		}
+		for _, r := range rs {
+			if r.Draft || r.Prerelease {
+				continue
+			}
+			v, err := parseVersion(strings.TrimPrefix(r.TagName, "v"))
+			if err != nil {
+				continue
+			}
+			versions = append(versions, v)
+		}
+		var next string
+		if m := nextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+		return versions, next, nil
+	}
+	for _, m := range releaseLink.FindAllStringSubmatch(string(b), -1) {
+		v, err := ParseVersion(m[1])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, "", nil
+}
+
+// NativeArch returns the architecture name used in release URLs
+// for the running system.
+func NativeArch() string {
+	for arch, ga := range goarch {
+		if ga == runtime.GOARCH {
+			return arch
+		}
+	}
+	return runtime.GOARCH
+}
@@ -0,0 +1,167 @@
+package versionutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConstraints(t *testing.T) {
+	var releases []Version
+	for _, s := range []string{"17.06.0-ce", "17.06.2-ce", "17.06.1-ce", "17.09.0-ce", "18.09.0", "18.09.1", "18.09.2-rc1"} {
+		v, err := ParseVersion(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, v)
+	}
+
+	cases := []struct {
+		Constraint string
+		Expected   string
+	}{
+		{"17.06", "17.06.2-ce"},
+		{"v17.06.x", "17.06.2-ce"},
+		{"18.09", "18.09.2-rc1"},
+		{"18.09.0", "18.09.0"},
+		{"18.06.1-ce", "18.06.1-ce"},
+		{"19.03", ""},
+	}
+	for _, tc := range cases {
+		c, err := ParseConstraint(tc.Constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.Resolve(releases)
+		if tc.Expected == "" {
+			if err == nil {
+				t.Errorf("Expected no match for %s, got %s", tc.Constraint, v)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Unexpected error resolving %s: %v", tc.Constraint, err)
+			continue
+		}
+		if v.String() != tc.Expected {
+			t.Errorf("Unexpected resolved version for %s: %s, expected %s", tc.Constraint, v, tc.Expected)
+		}
+	}
+
+	if _, err := ParseConstraint("latest"); err == nil {
+		t.Fatal("Expected error parsing invalid constraint")
+	}
+}
+
+func TestConstraintIndexURL(t *testing.T) {
+	for constraint, expected := range map[string]string{
+		"17.06":      "https://download.docker.com/linux/static/test/aarch64/",
+		"18.09":      "https://download.docker.com/linux/static/test/aarch64/",
+		"1.13":       "",
+		"1.12.6":     "",
+		"17.03.0-ce": "https://download.docker.com/linux/static/test/aarch64/",
+	} {
+		c, err := ParseConstraint(constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := c.IndexURL("linux", "aarch64", "test"); actual != expected {
+			t.Errorf("Unexpected index for %s: %q, expected %q", constraint, actual, expected)
+		}
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="../">../</a>
+<a href="docker-18.09.0.tgz">docker-18.09.0.tgz</a>
+<a href="docker-18.09.1.tgz">docker-18.09.1.tgz</a>
+<a href="docker-rootless-extras-19.03.0.tgz">docker-rootless-extras-19.03.0.tgz</a>
+</pre></body></html>`)
+	}))
+	defer server.Close()
+
+	versions, err := ListReleases(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].String() != "18.09.0" || versions[1].String() != "18.09.1" {
+		t.Fatalf("Unexpected releases: %v", versions)
+	}
+}
+
+func TestComponentConstraints(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		// Older releases are on the next page
+		if r.URL.Query().Get("page") == "2" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/?page=1>; rel="prev", <%s/?page=1>; rel="first"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"tag_name": "v2.19.1"}]`)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/?page=2>; rel="next", <%s/?page=2>; rel="last"`, server.URL, server.URL))
+		fmt.Fprint(w, `[
+	{"tag_name": "v2.21.0-rc.1", "prerelease": true},
+	{"tag_name": "v2.20.3"},
+	{"tag_name": "v2.20.2"},
+	{"tag_name": "v2.22.0", "draft": true}
+]`)
+	}))
+	defer server.Close()
+
+	versions, err := ListReleases(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Unexpected releases: %v", versions)
+	}
+
+	cases := []struct {
+		Constraint string
+		Expected   string
+	}{
+		{"2.20", "compose-2.20.3"},
+		{"2.19.x", "compose-2.19.1"},
+		{"2.18.0", "compose-2.18.0"},
+		{"2.21", ""},
+	}
+	for _, tc := range cases {
+		c, err := ParseComponentConstraint(ComponentCompose, tc.Constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.Resolve(versions)
+		if tc.Expected == "" {
+			if err == nil {
+				t.Errorf("Expected no match for %s, got %s", tc.Constraint, v)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Unexpected error resolving %s: %v", tc.Constraint, err)
+			continue
+		}
+		if v.String() != tc.Expected {
+			t.Errorf("Unexpected resolved version for %s: %s, expected %s", tc.Constraint, v, tc.Expected)
+		}
+	}
+
+	c, err := ParseComponentConstraint(ComponentBuildx, "0.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "https://api.github.com/repos/docker/buildx/releases?per_page=100"; c.IndexURL("linux", "x86_64", "stable") != expected {
+		t.Fatalf("Unexpected index for %s: %q, expected %q", c, c.IndexURL("linux", "x86_64", "stable"), expected)
+	}
+
+	for _, s := range []string{"1.29", "1.29.2"} {
+		if _, err := ParseComponentConstraint(ComponentCompose, s); err == nil {
+			t.Errorf("Expected error for standalone compose %s", s)
+		}
+	}
+	if _, err := ParseComponentConstraint("swarm", "1.2"); err == nil {
+		t.Error("Expected error for unknown component")
+	}
+}
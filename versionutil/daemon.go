@@ -0,0 +1,24 @@
+package versionutil
+
+// DaemonCommand returns the binary which runs the daemon for the
+// version and the arguments selecting the daemon mode. Releases
+// before 1.12 run the daemon from the docker binary, using the
+// "daemon" subcommand from 1.8 and the -d flag before.
+func (v Version) DaemonCommand() (string, []string) {
+	if v.LessThan(*tagVersion(1, 8, 0, "dev")) {
+		return "docker", []string{"-d"}
+	}
+	if v.LessThan(*tagVersion(1, 12, 0, "dev")) {
+		return "docker", []string{"daemon"}
+	}
+	return "dockerd", nil
+}
+
+// DataRootFlag returns the daemon flag setting the directory the
+// daemon state is stored in, named --graph before 17.05.
+func (v Version) DataRootFlag() string {
+	if v.LessThan(*tagVersion(17, 5, 0, "ce")) {
+		return "--graph"
+	}
+	return "--data-root"
+}
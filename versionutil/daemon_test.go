@@ -0,0 +1,36 @@
+package versionutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDaemonCommand(t *testing.T) {
+	cases := []struct {
+		Version  string
+		Command  string
+		DataRoot string
+	}{
+		{"1.7.1", "docker -d", "--graph"},
+		{"1.8.0-rc1", "docker daemon", "--graph"},
+		{"1.11.2", "docker daemon", "--graph"},
+		{"1.12.0-rc1", "dockerd", "--graph"},
+		{"17.03.2-ce", "dockerd", "--graph"},
+		{"17.05.0-ce-rc1", "dockerd", "--data-root"},
+		{"17.05.0-ce", "dockerd", "--data-root"},
+		{"18.09.0@abcdef1234", "dockerd", "--data-root"},
+	}
+	for _, tc := range cases {
+		v, err := ParseVersion(tc.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary, args := v.DaemonCommand()
+		if command := strings.Join(append([]string{binary}, args...), " "); command != tc.Command {
+			t.Errorf("Unexpected daemon command for %s: %q, expected %q", tc.Version, command, tc.Command)
+		}
+		if flag := v.DataRootFlag(); flag != tc.DataRoot {
+			t.Errorf("Unexpected data root flag for %s: %s, expected %s", tc.Version, flag, tc.DataRoot)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package versionutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VersionFile is the name of the file which pins the Docker
+// version used by a project.
+const VersionFile = ".docker-version"
+
+// ErrNoVersionFile is returned when no version file is found
+var ErrNoVersionFile = errors.New("no " + VersionFile + " found")
+
+// FindVersionFile looks for the version file in dir and each of
+// its parents, returning the version constraint and the path of
+// the file.
+func FindVersionFile(dir string) (Constraint, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Constraint{}, "", err
+	}
+	for {
+		p := filepath.Join(dir, VersionFile)
+		if _, err := os.Stat(p); err == nil {
+			v, err := ReadVersionFile(p)
+			return v, p, err
+		} else if !os.IsNotExist(err) {
+			return Constraint{}, "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Constraint{}, "", ErrNoVersionFile
+		}
+		dir = parent
+	}
+}
+
+// ReadVersionFile reads the version constraint from the first line
+// of the file which is not empty or a "#" comment. The constraint
+// is an exact version or a release line such as "17.06".
+func ReadVersionFile(p string) (Constraint, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Constraint{}, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c, err := ParseConstraint(line)
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid version in %s: %v", p, err)
+		}
+		return c, nil
+	}
+	if err := s.Err(); err != nil {
+		return Constraint{}, err
+	}
+	return Constraint{}, fmt.Errorf("no version in %s", p)
+}
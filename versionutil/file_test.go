@@ -0,0 +1,68 @@
+package versionutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindVersionFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "versionutil-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	project := filepath.Join(td, "project")
+	nested := filepath.Join(project, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := FindVersionFile(nested); err != ErrNoVersionFile {
+		t.Fatalf("Expected no version file error, got %v", err)
+	}
+
+	vf := filepath.Join(project, VersionFile)
+	if err := ioutil.WriteFile(vf, []byte("# Engine under test\n\n17.06.0-ce\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, p, err := FindVersionFile(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != vf {
+		t.Fatalf("Unexpected version file %s, expected %s", p, vf)
+	}
+	if v.String() != "17.06.0-ce" {
+		t.Fatalf("Unexpected version %s", v)
+	}
+
+	// Closest version file is used
+	if err := ioutil.WriteFile(filepath.Join(nested, VersionFile), []byte("18.09.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, err := FindVersionFile(nested); err != nil {
+		t.Fatal(err)
+	} else if v.String() != "18.09.0" {
+		t.Fatalf("Unexpected version %s", v)
+	}
+
+	// Release lines are resolved by the caller
+	if err := ioutil.WriteFile(filepath.Join(nested, VersionFile), []byte("17.06\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, _, err := FindVersionFile(nested); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.Exact(); ok || c.String() != "17.06" {
+		t.Fatalf("Unexpected constraint %s", c)
+	}
+
+	if err := ioutil.WriteFile(vf, []byte("latest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FindVersionFile(project); err == nil {
+		t.Fatal("Expected error for invalid version")
+	}
+}
@@ -0,0 +1,233 @@
+package versionutil
+
+import (
+	"strings"
+)
+
+// Archive is the format releases are distributed in
+type Archive string
+
+const (
+	// ArchiveBinary releases are distributed as a single
+	// docker binary.
+	ArchiveBinary Archive = "binary"
+
+	// ArchiveTarball releases are distributed as a gzipped
+	// tarball holding all binaries.
+	ArchiveTarball Archive = "tgz"
+)
+
+// Channel matches release tags to the channel the
+// release is published to.
+type Channel struct {
+	// TagPrefix is matched against the version tag, an
+	// empty prefix matches only untagged versions.
+	TagPrefix string
+
+	// Name is the name of the channel used in the URL
+	Name string
+}
+
+// Release describes how a range of versions was packaged
+// and distributed.
+type Release struct {
+	// Name identifies the packaging era
+	Name string
+
+	// Before is the first version after this release era,
+	// the last era has no upper bound.
+	Before *Version
+
+	// IndexTemplate is the location listing the releases in
+	// a channel, using the same replacements as URLTemplate.
+	// Empty when releases cannot be listed.
+	IndexTemplate string
+
+	// URLTemplate is the download location. The template
+	// replaces "{os}", "{Os}" (capitalized), "{arch}",
+	// "{goarch}" (the Go name for the architecture),
+	// "{channel}", "{version}" and "{tag}" (prefixed with
+	// "-" when the version is tagged).
+	URLTemplate string
+
+	// Archive is the format of the downloaded release
+	Archive Archive
+
+	// BinaryDir is the directory holding the binaries in
+	// release tarballs.
+	BinaryDir string
+
+	// Binaries are the binaries every release in the era
+	// provides.
+	Binaries []string
+
+	// InitBinary is the init binary which accompanies
+	// dynamic builds of the docker binary, empty when no
+	// init binary is used.
+	InitBinary string
+
+	// Channels are matched in order against the version tag,
+	// versions which match no channel have no download.
+	Channels []Channel
+
+	// BundledNames maps the binaries of a component to the
+	// names used when bundled with Docker releases.
+	BundledNames map[string]string
+
+	// Unsupported is the reason versions in the era cannot be
+	// installed, empty when they can be.
+	Unsupported string
+}
+
+func tagVersion(major, minor, release int, tag string) *Version {
+	v := StaticVersion(major, minor, release)
+	v.Tag = tag
+	return &v
+}
+
+// releases are the release eras, ordered by version
+var releases = []Release{
+	{
+		// Dynamic builds require dockerinit, named to
+		// match the versioned docker binary.
+		Name:        "dockerinit",
+		Before:      tagVersion(1, 10, 0, "dev"),
+		URLTemplate: "https://get.docker.com/builds/{Os}/{arch}/docker-{version}",
+		Archive:     ArchiveBinary,
+		BinaryDir:   "usr/local/bin",
+		Binaries:    []string{"docker"},
+		InitBinary:  "dockerinit",
+		Channels:    []Channel{{}},
+	},
+	{
+		// The docker binary re-executes itself for
+		// initialization, dockerinit is no longer used.
+		Name:        "static",
+		Before:      tagVersion(1, 11, 0, "rc1"),
+		URLTemplate: "https://get.docker.com/builds/{Os}/{arch}/docker-{version}",
+		Archive:     ArchiveBinary,
+		BinaryDir:   "usr/local/bin",
+		Binaries:    []string{"docker"},
+		Channels:    []Channel{{}},
+	},
+	{
+		// Containerd and runc are distributed alongside
+		// docker in a tarball.
+		Name:        "multi-binary",
+		Before:      tagVersion(17, 0, 0, "dev"),
+		URLTemplate: "https://get.docker.com/builds/{Os}/{arch}/docker-{version}.tgz",
+		Archive:     ArchiveTarball,
+		BinaryDir:   "docker",
+		Binaries:    []string{"docker"},
+		Channels:    []Channel{{}},
+	},
+	{
+		// Community edition releases are tagged "ce".
+		Name:          "ce",
+		Before:        tagVersion(18, 0, 0, "dev"),
+		IndexTemplate: "https://download.docker.com/{os}/static/{channel}/{arch}/",
+		URLTemplate:   "https://download.docker.com/{os}/static/{channel}/{arch}/docker-{version}{tag}.tgz",
+		Archive:       ArchiveTarball,
+		BinaryDir:     "docker",
+		Binaries:      []string{"docker", "dockerd"},
+		Channels: []Channel{
+			{TagPrefix: "ce-rc", Name: "test"},
+			{TagPrefix: "ce", Name: "stable"},
+		},
+	},
+	{
+		// Releases from 18.09 are no longer tagged "ce".
+		Name:          "stable",
+		IndexTemplate: "https://download.docker.com/{os}/static/{channel}/{arch}/",
+		URLTemplate:   "https://download.docker.com/{os}/static/{channel}/{arch}/docker-{version}{tag}.tgz",
+		Archive:       ArchiveTarball,
+		BinaryDir:     "docker",
+		Binaries:      []string{"docker", "dockerd"},
+		Channels: []Channel{
+			{TagPrefix: "ce-rc", Name: "test"},
+			{TagPrefix: "ce", Name: "stable"},
+			{TagPrefix: "rc", Name: "test"},
+			{Name: "stable"},
+		},
+		// TODO: Support edge channel
+	},
+}
+
+// goarch maps architecture names used in release URLs to
+// the names used by Go.
+var goarch = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armhf":   "arm",
+	"s390x":   "s390x",
+	"ppc64le": "ppc64le",
+}
+
+// Release returns the release era for the version. A release
+// without binaries is returned for unknown components.
+func (v Version) Release() Release {
+	rs := releases
+	if v.Component != "" {
+		rs = componentReleases[v.Component]
+	}
+	for _, r := range rs {
+		if r.Before == nil || v.LessThan(*r.Before) {
+			return r
+		}
+	}
+	if len(rs) == 0 {
+		return Release{Name: v.Component}
+	}
+	return rs[len(rs)-1]
+}
+
+// channel returns the channel the version is published to,
+// false is returned if the version is not published.
+func (r Release) channel(v Version) (string, bool) {
+	for _, c := range r.Channels {
+		if c.TagPrefix == "" {
+			if v.Tag == "" {
+				return c.Name, true
+			}
+		} else if strings.HasPrefix(v.Tag, c.TagPrefix) {
+			return c.Name, true
+		}
+	}
+	return "", false
+}
+
+// url returns the download URL for the version, empty if
+// the version is not published.
+func (r Release) url(v Version, os, arch string) string {
+	channel, ok := r.channel(v)
+	if !ok {
+		return ""
+	}
+	var tag string
+	if v.Tag != "" {
+		tag = "-" + v.Tag
+	}
+	return expandTemplate(r.URLTemplate, os, arch, channel, v.VersionString(), tag)
+}
+
+// indexURL returns the location listing the releases in the
+// channel, empty if releases cannot be listed.
+func (r Release) indexURL(os, arch, channel string) string {
+	return expandTemplate(r.IndexTemplate, os, arch, channel, "", "")
+}
+
+func expandTemplate(t, os, arch, channel, version, tag string) string {
+	var capitalized string
+	if os != "" {
+		capitalized = strings.ToUpper(os[:1]) + os[1:]
+	}
+	return strings.NewReplacer(
+		"{os}", os,
+		"{Os}", capitalized,
+		"{arch}", arch,
+		"{goarch}", goarch[arch],
+		"{channel}", channel,
+		"{version}", version,
+		"{tag}", tag,
+	).Replace(t)
+}
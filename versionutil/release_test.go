@@ -0,0 +1,88 @@
+package versionutil
+
+import "testing"
+
+func TestReleases(t *testing.T) {
+	cases := []struct {
+		Version    string
+		Release    string
+		Archive    Archive
+		InitBinary string
+	}{
+		{"0.8.1", "dockerinit", ArchiveBinary, "dockerinit"},
+		{"1.9.1", "dockerinit", ArchiveBinary, "dockerinit"},
+		{"1.10.0-dev", "static", ArchiveBinary, ""},
+		{"1.10.3", "static", ArchiveBinary, ""},
+		{"1.11.0-dev", "static", ArchiveBinary, ""},
+		{"1.11.0-rc1", "multi-binary", ArchiveTarball, ""},
+		{"1.13.1", "multi-binary", ArchiveTarball, ""},
+		{"17.03.0-ce", "ce", ArchiveTarball, ""},
+		{"17.06.0-dev", "ce", ArchiveTarball, ""},
+		{"18.06.1-ce", "stable", ArchiveTarball, ""},
+		{"18.09.0", "stable", ArchiveTarball, ""},
+		{"20.10.7", "stable", ArchiveTarball, ""},
+	}
+	for _, tc := range cases {
+		v, err := ParseVersion(tc.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := v.Release()
+		if r.Name != tc.Release {
+			t.Errorf("Unexpected release for %s: %s, expected %s", tc.Version, r.Name, tc.Release)
+			continue
+		}
+		if r.Archive != tc.Archive {
+			t.Errorf("Unexpected archive for %s: %s, expected %s", tc.Version, r.Archive, tc.Archive)
+		}
+		if r.InitBinary != tc.InitBinary {
+			t.Errorf("Unexpected init binary for %s: %q, expected %q", tc.Version, r.InitBinary, tc.InitBinary)
+		}
+	}
+}
+
+func TestReleaseOrdering(t *testing.T) {
+	for i, r := range releases {
+		if r.Before == nil {
+			if i != len(releases)-1 {
+				t.Fatalf("Release %s without upper bound must be last", r.Name)
+			}
+			continue
+		}
+		if len(r.Binaries) == 0 {
+			t.Errorf("Release %s has no binaries", r.Name)
+		}
+		if i > 0 && !releases[i-1].Before.LessThan(*r.Before) {
+			t.Errorf("Release %s out of order", r.Name)
+		}
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	cases := []struct {
+		Version  string
+		Expected string
+	}{
+		{"1.6.2", "https://get.docker.com/builds/Linux/x86_64/docker-1.6.2"},
+		{"1.10.3", "https://get.docker.com/builds/Linux/x86_64/docker-1.10.3"},
+		{"1.11.0-rc1", ""},
+		{"1.12.6", "https://get.docker.com/builds/Linux/x86_64/docker-1.12.6.tgz"},
+		{"1.13.0-dev", ""},
+		{"17.03.0-ce", "https://download.docker.com/linux/static/stable/x86_64/docker-17.03.0-ce.tgz"},
+		{"17.06.0-ce-rc1", "https://download.docker.com/linux/static/test/x86_64/docker-17.06.0-ce-rc1.tgz"},
+		{"17.06.0-dev", ""},
+		{"18.06.1-ce", "https://download.docker.com/linux/static/stable/x86_64/docker-18.06.1-ce.tgz"},
+		{"18.09.0", "https://download.docker.com/linux/static/stable/x86_64/docker-18.09.0.tgz"},
+		{"18.09.1-rc1", "https://download.docker.com/linux/static/test/x86_64/docker-18.09.1-rc1.tgz"},
+		{"19.03.0-dev", ""},
+	}
+	for _, tc := range cases {
+		v, err := ParseVersion(tc.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := v.downloadURL("linux", "x86_64"); actual != tc.Expected {
+			t.Errorf("Unexpected URL for %s: %q, expected %q", tc.Version, actual, tc.Expected)
+		}
+	}
+}
@@ -19,13 +19,27 @@ type Version struct {
 	versionNumber [3]int
 	Tag           string
 	Commit        string
+
+	// Component is the component distributed separately
+	// from Docker, empty for Docker itself.
+	Component string
+
+	// Arch is the architecture of the release, empty for
+	// the architecture of the running system.
+	Arch string
 }
 
 func (v Version) String() string {
 	s := v.Name
+	if v.Component != "" {
+		s = v.Component + "-" + s
+	}
 	if v.Commit != "" {
 		s += "@" + v.Commit
 	}
+	if v.Arch != "" {
+		s += "." + v.Arch
+	}
 	return s
 }
 
@@ -34,55 +48,28 @@ func (v Version) VersionString() string {
 }
 
 func (v Version) downloadURL(os, arch string) string {
-	// downloadLocation
-	// Install stable
-	// https://download.docker.com/linux/static/stable/x86_64/
-	// Install test
-	// https://download.docker.com/linux/static/test/x86_64/
-	// Install edge
-	// https://download.docker.com/linux/static/edge/x86_64/
-	// Install release (pre 17.03)
-	// https://get.docker.com/builds/Linux/x86_64/docker-1.9.0
-	suffix := ".tgz"
-	tarVersion := StaticVersion(1, 11, 0)
-	tarVersion.Tag = "rc1"
-	if v.LessThan(tarVersion) {
-		suffix = ""
-	}
-
-	if v.Tag == "" {
-		if v.versionNumber[0] < 17 {
-			return fmt.Sprintf("https://get.docker.com/builds/%s/%s/docker-%s%s", os, arch, v.VersionString(), suffix)
-		}
-	}
-
-	if strings.HasPrefix(v.Tag, "ce") || (v.Tag == "" && v.versionNumber[0] >= 18) {
-		channel := "stable"
-		if strings.HasPrefix(v.Tag, "ce-rc") {
-			channel = "test"
-		}
-		// TODO: Support edge channel
-
-		// Handles 18.09.0 and later which drop -ce
-		var endSuffix string
-		if v.Tag != "" {
-			suffix = "-" + v.Tag + suffix
-		}
-
-		return fmt.Sprintf("https://download.docker.com/%s/static/%s/%s/docker-%s%s.tgz", os, channel, arch, v.VersionString(), endSuffix)
-	}
-
-	return ""
-
+	return v.Release().url(v, os, arch)
 }
 
 var (
-	versionRegexp = regexp.MustCompile(`v?([0-9]+).([0-9]+).([0-9]+)(?:-((?:[a-z][a-z0-9]+)(?:-[a-z0-9_]+)*)(?:@([a-f0-9]+(?:-dirty)?))?)?`)
+	versionRegexp = regexp.MustCompile(`v?([0-9]+).([0-9]+).([0-9]+)(?:-((?:[a-z][a-z0-9]+)(?:-[a-z0-9_]+)*))?(?:@([a-f0-9]+(?:-dirty|-[a-f0-9]+(?:-dirty)?)?))?`)
 )
 
 // ParseVersion parses a version string as used by
-// Docker version command and git tags.
+// Docker version command and git tags. Versions of other
+// components are prefixed with the component name, such
+// as "containerd-1.2.5". Versions for another architecture
+// are suffixed with the architecture, such as "18.09.0.aarch64".
 func ParseVersion(s string) (v Version, err error) {
+	for component := range componentReleases {
+		if strings.HasPrefix(s, component+"-") {
+			return ParseComponentVersion(component, s[len(component)+1:])
+		}
+	}
+	return parseVersion(s)
+}
+
+func parseVersion(s string) (v Version, err error) {
 	submatches := versionRegexp.FindStringSubmatch(s)
 	if len(submatches) != 6 {
 		return Version{}, errors.New("no version match")
@@ -108,6 +95,11 @@ func ParseVersion(s string) (v Version, err error) {
 	if v.Commit != "" {
 		v.Name = v.Name[0 : len(v.Name)-len(v.Commit)-1]
 	}
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		if _, ok := goarch[s[i+1:]]; ok {
+			v.Arch = s[i+1:]
+		}
+	}
 
 	return
 }
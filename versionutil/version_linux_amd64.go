@@ -2,7 +2,12 @@ package versionutil
 
 // DownloadURL returns the download URL for the
 // operating system and architecture for the system
-// being built for.
+// being built for, or the architecture of the version
+// when set.
 func (v Version) DownloadURL() string {
-	return v.downloadURL("linux", "x86_64")
+	arch := "x86_64"
+	if v.Arch != "" {
+		arch = v.Arch
+	}
+	return v.downloadURL("linux", arch)
 }
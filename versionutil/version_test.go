@@ -11,14 +11,14 @@ func TestVersionParsing(t *testing.T) {
 			Test: "0.8.1",
 			Expected: Version{
 				Name:          "0.8.1",
-				VersionNumber: [3]int{0, 8, 1},
+				versionNumber: [3]int{0, 8, 1},
 			},
 		},
 		{
 			Test: "0.8.1-dev",
 			Expected: Version{
 				Name:          "0.8.1-dev",
-				VersionNumber: [3]int{0, 8, 1},
+				versionNumber: [3]int{0, 8, 1},
 				Tag:           "dev",
 			},
 		},
@@ -26,7 +26,7 @@ func TestVersionParsing(t *testing.T) {
 			Test: "v0.8.1-dev",
 			Expected: Version{
 				Name:          "v0.8.1-dev",
-				VersionNumber: [3]int{0, 8, 1},
+				versionNumber: [3]int{0, 8, 1},
 				Tag:           "dev",
 			},
 		},
@@ -34,19 +34,62 @@ func TestVersionParsing(t *testing.T) {
 			Test: "v0.8.1-rc1",
 			Expected: Version{
 				Name:          "v0.8.1-rc1",
-				VersionNumber: [3]int{0, 8, 1},
+				versionNumber: [3]int{0, 8, 1},
 				Tag:           "rc1",
 			},
 		},
 		{
 			Test: "v0.8.1-dev@aaffbb1234",
 			Expected: Version{
-				Name:          "v0.8.1-dev@aaffbb1234",
-				VersionNumber: [3]int{0, 8, 1},
+				Name:          "v0.8.1-dev",
+				versionNumber: [3]int{0, 8, 1},
 				Tag:           "dev",
 				Commit:        "aaffbb1234",
 			},
 		},
+		{
+			Test: "18.09.0@aaffbb1234",
+			Expected: Version{
+				Name:          "18.09.0",
+				versionNumber: [3]int{18, 9, 0},
+				Commit:        "aaffbb1234",
+			},
+		},
+		{
+			Test: "18.09.0@aaffbb1234-dirty.aarch64",
+			Expected: Version{
+				Name:          "18.09.0",
+				versionNumber: [3]int{18, 9, 0},
+				Commit:        "aaffbb1234-dirty",
+				Arch:          "aarch64",
+			},
+		},
+		{
+			Test: "1.0.0-dev@aaffbb1234-0123456789ab-dirty",
+			Expected: Version{
+				Name:          "1.0.0-dev",
+				versionNumber: [3]int{1, 0, 0},
+				Tag:           "dev",
+				Commit:        "aaffbb1234-0123456789ab-dirty",
+			},
+		},
+		{
+			Test: "18.09.0@aaffbb1234-d123456789ab.aarch64",
+			Expected: Version{
+				Name:          "18.09.0",
+				versionNumber: [3]int{18, 9, 0},
+				Commit:        "aaffbb1234-d123456789ab",
+				Arch:          "aarch64",
+			},
+		},
+		{
+			Test: "18.09.0.aarch64",
+			Expected: Version{
+				Name:          "18.09.0",
+				versionNumber: [3]int{18, 9, 0},
+				Arch:          "aarch64",
+			},
+		},
 	}
 	for _, tc := range cases {
 		v, err := ParseVersion(tc.Test)
@@ -56,6 +99,9 @@ func TestVersionParsing(t *testing.T) {
 		if v != tc.Expected {
 			t.Errorf("Mismatched version value\n\tActual: %#v\n\tExpected: %#v", v, tc.Expected)
 		}
+		if s := v.String(); s != tc.Test {
+			t.Errorf("Version %s does not round trip, got %s", tc.Test, s)
+		}
 	}
 }
 